@@ -45,7 +45,11 @@ type backend struct {
 	lockManager      *keysutil.LockManager
 	cachedConfig     *Config
 	cachedConfigLock *sync.RWMutex
+	wrappingKeyLock  *sync.Mutex
 	idGen            uniqueIdGenerator
+
+	cachedKeyIdNamespace     string
+	cachedKeyIdNamespaceLock *sync.RWMutex
 }
 
 // Factory returns a new backend as logical.Backend.
@@ -71,20 +75,37 @@ func createBackend(conf *logical.BackendConfig) (*backend, error) {
 
 	b.id = conf.BackendUUID
 	b.cachedConfigLock = new(sync.RWMutex)
-	b.idGen = friendlyIdGenerator{}
+	b.wrappingKeyLock = new(sync.Mutex)
+	b.cachedKeyIdNamespaceLock = new(sync.RWMutex)
+	b.idGen = jtiGenerator{}
 
 	b.Backend = &framework.Backend{
 		BackendType: logical.TypeLogical,
 		Help:        strings.TrimSpace(backendHelp),
 		PathsSpecial: &logical.Paths{
-			Unauthenticated: []string{"jwks"},
+			Unauthenticated: []string{
+				"jwks",
+				discoveryPath,
+				issuersPath + "/+/jwks",
+				issuersPath + "/+/" + discoveryPath,
+			},
 		},
 		Paths: framework.PathAppend(
 			pathRole(&b),
+			pathKey(&b),
+			pathIssuer(&b),
+			pathBackup(&b),
 			[]*framework.Path{
 				pathConfig(&b),
+				pathRotate(&b),
+				pathRevoke(&b),
+				pathRevokeToken(&b),
+				pathImport(&b),
+				pathWrappingKey(&b),
 				pathJwks(&b),
+				pathDiscovery(&b),
 				pathSign(&b),
+				pathVerify(&b),
 			},
 		),
 		Secrets: []*framework.Secret{
@@ -116,12 +137,33 @@ func (b *backend) periodic(ctx context.Context, req *logical.Request) error {
 		return err
 	}
 
-	policy, err := b.getPolicy(ctx, req.Storage, config, req.MountPoint)
+	keyNames, err := b.listKeyNames(ctx, req.Storage)
 	if err != nil {
 		return err
 	}
 
-	return b.pruneKeyVersions(ctx, req.Storage, policy, config, req.MountPoint)
+	for _, keyName := range keyNames {
+
+		key, err := b.getSigningKey(ctx, req.Storage, config, keyName)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			continue
+		}
+
+		// Fetching the policy rotates it when due, so keys rotate on schedule even when the mount is idle
+		policy, err := b.getPolicy(ctx, req.Storage, keyName, key, req.MountPoint)
+		if err != nil {
+			return err
+		}
+
+		if err := b.pruneKeyVersions(ctx, req.Storage, policy, key, config, req.MountPoint); err != nil {
+			return err
+		}
+	}
+
+	return b.pruneTokenRevocations(ctx, req.Storage, config)
 }
 
 func (b *backend) invalidate(_ context.Context, key string) {
@@ -136,6 +178,10 @@ func (b *backend) invalidate(_ context.Context, key string) {
 		b.cachedConfigLock.Lock()
 		defer b.cachedConfigLock.Unlock()
 		b.cachedConfig = nil
+	case key == keyIdNamespacePath:
+		b.cachedKeyIdNamespaceLock.Lock()
+		defer b.cachedKeyIdNamespaceLock.Unlock()
+		b.cachedKeyIdNamespace = ""
 	}
 }
 
@@ -143,12 +189,12 @@ func (b *backend) clean(_ context.Context) {
 	// Nothing to do
 }
 
-func (b *backend) getPolicy(ctx context.Context, stg logical.Storage, config *Config, mount string) (*keysutil.Policy, error) {
+func (b *backend) getPolicy(ctx context.Context, stg logical.Storage, name string, key *Key, mount string) (*keysutil.Policy, error) {
 
 	polReq := keysutil.PolicyRequest{
 		Upsert:               true,
 		Storage:              stg,
-		Name:                 mainKeyName,
+		Name:                 name,
 		Derived:              false,
 		Convergent:           false,
 		Exportable:           false,
@@ -157,28 +203,7 @@ func (b *backend) getPolicy(ctx context.Context, stg logical.Storage, config *Co
 
 	var err error
 
-	switch config.SignatureAlgorithm {
-	case jose.RS256, jose.RS384, jose.RS512:
-		switch config.RSAKeyBits {
-		case 2048:
-			polReq.KeyType = keysutil.KeyType_RSA2048
-		case 3072:
-			polReq.KeyType = keysutil.KeyType_RSA3072
-		case 4096:
-			polReq.KeyType = keysutil.KeyType_RSA4096
-		default:
-			err = errutil.InternalError{Err: "unsupported RSA key size"}
-		}
-	case jose.ES256:
-		polReq.KeyType = keysutil.KeyType_ECDSA_P256
-	case jose.ES384:
-		polReq.KeyType = keysutil.KeyType_ECDSA_P384
-	case jose.ES512:
-		polReq.KeyType = keysutil.KeyType_ECDSA_P521
-	default:
-		err = errutil.InternalError{Err: "unknown/unsupported signature algorithm"}
-	}
-
+	polReq.KeyType, err = keyTypeForAlgorithm(key.SignatureAlgorithm, key.RSAKeyBits)
 	if err != nil {
 		return nil, err
 	}
@@ -188,26 +213,73 @@ func (b *backend) getPolicy(ctx context.Context, stg logical.Storage, config *Co
 		return nil, err
 	}
 
-	if err := b.rotateIfNecessary(ctx, stg, policy, config, mount); err != nil {
+	if err := b.rotateIfNecessary(ctx, stg, policy, key, mount); err != nil {
 		return nil, err
 	}
 
 	return policy, nil
 }
 
-func (b *backend) rotateIfNecessary(ctx context.Context, stg logical.Storage, policy *keysutil.Policy, config *Config, mount string) error {
+// keyTypeForAlgorithm returns the type of key generated for the signature algorithm.
+func keyTypeForAlgorithm(sigAlg jose.SignatureAlgorithm, rsaKeyBits int) (keysutil.KeyType, error) {
+	switch sigAlg {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+		switch rsaKeyBits {
+		case 2048:
+			return keysutil.KeyType_RSA2048, nil
+		case 3072:
+			return keysutil.KeyType_RSA3072, nil
+		case 4096:
+			return keysutil.KeyType_RSA4096, nil
+		default:
+			return 0, errutil.InternalError{Err: "unsupported RSA key size"}
+		}
+	case jose.ES256:
+		return keysutil.KeyType_ECDSA_P256, nil
+	case jose.ES384:
+		return keysutil.KeyType_ECDSA_P384, nil
+	case jose.ES512:
+		return keysutil.KeyType_ECDSA_P521, nil
+	case jose.EdDSA:
+		return keysutil.KeyType_ED25519, nil
+	default:
+		return 0, errutil.InternalError{Err: "unknown/unsupported signature algorithm"}
+	}
+}
+
+func (b *backend) rotateIfNecessary(ctx context.Context, stg logical.Storage, policy *keysutil.Policy, key *Key, mount string) error {
 	policy.Lock(true)
 	defer policy.Unlock()
 
-	latestKey, ok := policy.Keys[strconv.Itoa(policy.LatestVersion)]
-	if !ok {
+	rotatesAt, ok := nextRotation(policy, key)
+	if !ok || rotatesAt.After(time.Now()) {
 		return nil
 	}
 
-	if latestKey.CreationTime.Add(config.KeyRotationPeriod).After(time.Now()) {
-		return nil
+	return b.rotateLocked(ctx, stg, policy, mount)
+}
+
+// nextRotation returns when the next version of the policy is scheduled to be generated, if it rotates
+// automatically; the policy must be locked.
+func nextRotation(policy *keysutil.Policy, key *Key) (time.Time, bool) {
+
+	// Imported keys that disallow rotation remain the signing key
+	if policy.Imported && !policy.AllowImportedKeyRotation {
+		return time.Time{}, false
+	}
+
+	latestKey, ok := policy.Keys[strconv.Itoa(policy.LatestVersion)]
+	if !ok {
+		return time.Time{}, false
 	}
 
+	// Rotate early so the new version is published for the prepublish period before it signs
+	return latestKey.CreationTime.Add(key.RotationPeriod - key.PrepublishPeriod), true
+}
+
+// rotateLocked creates a new key version; the policy must be exclusively locked.
+func (b *backend) rotateLocked(ctx context.Context, stg logical.Storage, policy *keysutil.Policy, mount string) error {
+
 	err := policy.Rotate(ctx, stg, rand.Reader)
 	if err != nil {
 		return err
@@ -215,36 +287,100 @@ func (b *backend) rotateIfNecessary(ctx context.Context, stg logical.Storage, po
 
 	b.lockManager.InvalidatePolicy(policy.Name)
 
-	b.Logger().Info(fmt.Sprintf("Key Rotated: mount=%s", mount))
+	b.Logger().Info(fmt.Sprintf("Key Rotated: mount=%s, key=%s", mount, policy.Name))
 
 	return nil
 }
 
-func (b *backend) pruneKeyVersions(ctx context.Context, stg logical.Storage, policy *keysutil.Policy, config *Config, mount string) error {
+// rotateKeyFormat rotates the key to a new version generated in the key's current format.
+func (b *backend) rotateKeyFormat(ctx context.Context, stg logical.Storage, name string, previousKey *Key, key *Key, mount string) error {
+
+	b.Logger().Info(fmt.Sprintf("Key Format Rotation: mount=%s, key=%s", mount, name))
+
+	// Ensure any newly created policy uses the previous format, before rotating to the new one
+	policy, err := b.getPolicy(ctx, stg, name, previousKey, mount)
+	if err != nil {
+		return err
+	}
+
+	policy.Lock(true)
+	defer policy.Unlock()
+
+	policy.Type, err = keyTypeForAlgorithm(key.SignatureAlgorithm, key.RSAKeyBits)
+	if err != nil {
+		return err
+	}
+
+	// Record the algorithm of existing versions so they are published correctly until pruned
+	if err := b.recordKeyFormat(ctx, stg, policy.Name, policy.LatestVersion, previousKey.SignatureAlgorithm); err != nil {
+		return err
+	}
+
+	defer b.lockManager.InvalidatePolicy(name)
+
+	// Imported keys cannot be used with the new format and are replaced by a generated version
+	policy.AllowImportedKeyRotation = true
+
+	return policy.Rotate(ctx, stg, rand.Reader)
+}
+
+// maxTokenTTL returns the largest TTL of tokens that can be signed for any role, including any delay before they
+// become valid, which key versions are retained for after they stop signing new tokens.
+func (b *backend) maxTokenTTL(ctx context.Context, stg logical.Storage, config *Config) (time.Duration, error) {
+	maxTTL := config.TokenTTL
+
+	roleNames, err := stg.List(ctx, keyStorageRolePath+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, stg, roleName)
+		if err != nil {
+			return 0, err
+		}
+		if role == nil {
+			continue
+		}
+		maxTTL = durationMax(maxTTL, durationMin(role.maxTTL(config)+role.MaxNBFDelay, b.System().MaxLeaseTTL()))
+	}
+
+	return maxTTL, nil
+}
+
+func (b *backend) pruneKeyVersions(ctx context.Context, stg logical.Storage, policy *keysutil.Policy, key *Key, config *Config, mount string) error {
 
 	logger := b.Logger()
 
 	if logger.IsDebug() {
-		logger.Debug(fmt.Sprintf("Pruning Keys: mount=%s", mount))
+		logger.Debug(fmt.Sprintf("Pruning Keys: mount=%s, key=%s", mount, policy.Name))
+	}
+
+	tokenTTL, err := b.maxTokenTTL(ctx, stg, config)
+	if err != nil {
+		return err
 	}
 
 	policy.Lock(false)
 
+	retainedVersion := key.minRetainedVersion(policy.LatestVersion)
+
 	unexpiredVersion := intMax(policy.MinAvailableVersion, 1)
-	for ; unexpiredVersion < policy.LatestVersion; unexpiredVersion += 1 {
+	for ; unexpiredVersion < retainedVersion; unexpiredVersion += 1 {
 
 		keyVersion, ok := policy.Keys[strconv.Itoa(unexpiredVersion)]
 		if !ok {
 			continue
 		}
 
-		keyExpiresAt := keyVersion.CreationTime.Add(config.KeyRotationPeriod).Add(config.TokenTTL)
+		keyExpiresAt := key.versionExpiresAt(keyVersion.CreationTime, tokenTTL)
 
 		if logger.IsDebug() {
 			logger.Debug(
 				fmt.Sprintf(
-					"Checking Key: mount=%s, version=%d created=%s, expires=%s",
+					"Checking Key: mount=%s, key=%s, version=%d created=%s, expires=%s",
 					mount,
+					policy.Name,
 					unexpiredVersion,
 					keyVersion.CreationTime.Format(time.RFC3339),
 					keyExpiresAt.Format(time.RFC3339),
@@ -286,8 +422,9 @@ func (b *backend) pruneKeyVersions(ctx context.Context, stg logical.Storage, pol
 
 	logger.Info(
 		fmt.Sprintf(
-			"Key Trimmed: mount=%s, latest=%d, min-available=%d, min-decryption=%d",
+			"Key Trimmed: mount=%s, key=%s, latest=%d, min-available=%d, min-decryption=%d",
 			mount,
+			policy.Name,
 			policy.LatestVersion,
 			policy.MinAvailableVersion,
 			policy.MinDecryptionVersion,
@@ -20,6 +20,7 @@ import (
 	"context"
 	"github.com/go-test/deep"
 	"github.com/google/uuid"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"testing"
 	"time"
@@ -67,7 +68,7 @@ func TestRotate(t *testing.T) {
 		t.Fatalf("%s\n", err)
 	}
 
-	policy, err := b.getPolicy(context.Background(), *storage, config, "test")
+	policy, err := b.getPolicy(context.Background(), *storage, mainKeyName, config.mainKey(), "test")
 	if err != nil {
 		t.Fatalf("%s\n", err)
 	}
@@ -92,7 +93,7 @@ func TestRotate(t *testing.T) {
 	time.Sleep(config.KeyRotationPeriod + 1)
 
 	// Post-rotate #1 checks
-	policy, err = b.getPolicy(context.Background(), *storage, config, "test")
+	policy, err = b.getPolicy(context.Background(), *storage, mainKeyName, config.mainKey(), "test")
 	if err != nil {
 		t.Fatalf("%s\n", err)
 	}
@@ -113,7 +114,7 @@ func TestRotate(t *testing.T) {
 		t.Error("policy archive-min version", diff)
 	}
 
-	policy, err = b.getPolicy(context.Background(), *storage, config, "test")
+	policy, err = b.getPolicy(context.Background(), *storage, mainKeyName, config.mainKey(), "test")
 	if err != nil {
 		t.Fatalf("%s\n", err)
 	}
@@ -137,7 +138,7 @@ func TestRotate(t *testing.T) {
 
 	time.Sleep(config.KeyRotationPeriod + 1)
 
-	policy, err = b.getPolicy(context.Background(), *storage, config, "test")
+	policy, err = b.getPolicy(context.Background(), *storage, mainKeyName, config.mainKey(), "test")
 	if err != nil {
 		t.Fatalf("%s\n", err)
 	}
@@ -181,7 +182,7 @@ func TestPrune(t *testing.T) {
 		t.Fatalf("%s\n", err)
 	}
 
-	policy, err := b.getPolicy(context.Background(), *storage, config, "test")
+	policy, err := b.getPolicy(context.Background(), *storage, mainKeyName, config.mainKey(), "test")
 	if err != nil {
 		t.Fatalf("%s\n", err)
 	}
@@ -191,7 +192,7 @@ func TestPrune(t *testing.T) {
 
 	time.Sleep(config.KeyRotationPeriod + 1)
 
-	policy, err = b.getPolicy(context.Background(), *storage, config, "test")
+	policy, err = b.getPolicy(context.Background(), *storage, mainKeyName, config.mainKey(), "test")
 	if err != nil {
 		t.Fatalf("%s\n", err)
 	}
@@ -201,7 +202,7 @@ func TestPrune(t *testing.T) {
 
 	time.Sleep(config.KeyRotationPeriod + 1)
 
-	policy, err = b.getPolicy(context.Background(), *storage, config, "test")
+	policy, err = b.getPolicy(context.Background(), *storage, mainKeyName, config.mainKey(), "test")
 	if err != nil {
 		t.Fatalf("%s\n", err)
 	}
@@ -211,7 +212,7 @@ func TestPrune(t *testing.T) {
 
 	time.Sleep(config.KeyRotationPeriod + config.TokenTTL + 1)
 
-	err = b.pruneKeyVersions(context.Background(), *storage, policy, config, "test")
+	err = b.pruneKeyVersions(context.Background(), *storage, policy, config.mainKey(), config, "test")
 	if err != nil {
 		t.Fatalf("%s\n", err)
 	}
@@ -247,3 +248,196 @@ func TestPrune(t *testing.T) {
 		t.Error("jwks key count", diff)
 	}
 }
+
+func TestPeriodicRotate(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	_, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration: "2s",
+		keyTokenTTL:         "1s",
+	})
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	config, err := b.getConfig(context.Background(), *storage)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	policy, err := b.getPolicy(context.Background(), *storage, mainKeyName, config.mainKey(), "test")
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+	if diff := deep.Equal(policy.LatestVersion, 1); diff != nil {
+		t.Error("policy latest version", diff)
+	}
+
+	time.Sleep(config.KeyRotationPeriod + 1)
+
+	req := &logical.Request{
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	if err := b.periodic(context.Background(), req); err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	// Check policy without triggering rotation
+	policy, _, err = b.lockManager.GetPolicy(context.Background(), keysutil.PolicyRequest{Storage: *storage, Name: mainKeyName}, nil)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+	if diff := deep.Equal(policy.LatestVersion, 2); diff != nil {
+		t.Error("policy latest version", diff)
+	}
+}
+
+func TestPruneVerificationKeyRetention(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	_, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration:         "2s",
+		keyTokenTTL:                 "1s",
+		keyVerificationKeyRetention: "1m",
+	})
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	config, err := b.getConfig(context.Background(), *storage)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := rotateKey(b, storage, mainKeyName); err != nil {
+			t.Fatalf("%s\n", err)
+		}
+	}
+
+	time.Sleep(config.KeyRotationPeriod + config.TokenTTL + 1)
+
+	// Check policy without triggering rotation
+	policy, _, err := b.lockManager.GetPolicy(context.Background(), keysutil.PolicyRequest{Storage: *storage, Name: mainKeyName}, nil)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	err = b.pruneKeyVersions(context.Background(), *storage, policy, config.mainKey(), config, "test")
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	// All tokens have expired but the versions are retained
+	if diff := deep.Equal(policy.LatestVersion, 3); diff != nil {
+		t.Error("policy latest version", diff)
+	}
+	if diff := deep.Equal(policy.MinDecryptionVersion, 1); diff != nil {
+		t.Error("policy min-decryption version", diff)
+	}
+
+	// Fetching will rotate again, leaving four keys
+	jwks, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	if diff := deep.Equal(len(jwks.Keys), 4); diff != nil {
+		t.Error("jwks key count", diff)
+	}
+}
+
+func TestPruneMinVerificationKeys(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	_, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration:    "2s",
+		keyTokenTTL:            "1s",
+		keyMinVerificationKeys: 2,
+	})
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	config, err := b.getConfig(context.Background(), *storage)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := rotateKey(b, storage, mainKeyName); err != nil {
+			t.Fatalf("%s\n", err)
+		}
+	}
+
+	time.Sleep(config.KeyRotationPeriod + config.TokenTTL + 1)
+
+	// Check policy without triggering rotation
+	policy, _, err := b.lockManager.GetPolicy(context.Background(), keysutil.PolicyRequest{Storage: *storage, Name: mainKeyName}, nil)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	err = b.pruneKeyVersions(context.Background(), *storage, policy, config.mainKey(), config, "test")
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	// Only the minimum number of versions are retained
+	if diff := deep.Equal(policy.LatestVersion, 4); diff != nil {
+		t.Error("policy latest version", diff)
+	}
+	if diff := deep.Equal(policy.MinAvailableVersion, 3); diff != nil {
+		t.Error("policy min-available version", diff)
+	}
+	if diff := deep.Equal(policy.MinDecryptionVersion, 3); diff != nil {
+		t.Error("policy min-decryption version", diff)
+	}
+}
+
+func TestMaxTokenTTL(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyTokenTTL:    "3m",
+		keyMaxTokenTTL: "1h",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectMaxTokenTTL := func(expected time.Duration) {
+		t.Helper()
+
+		config, err := b.getConfig(context.Background(), *storage)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		maxTTL, err := b.maxTokenTTL(context.Background(), *storage, config)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if diff := deep.Equal(expected, maxTTL); diff != nil {
+			t.Error(diff)
+		}
+	}
+
+	// Without roles, no tokens can be signed beyond the default ttl
+	expectMaxTokenTTL(3 * time.Minute)
+
+	if err := writeRoleData(b, storage, "short", map[string]interface{}{
+		keyIssuer: "short.example.com",
+		keyMaxTTL: "10m",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	expectMaxTokenTTL(10 * time.Minute)
+
+	// Roles without a max ttl can sign tokens up to the config's max ttl
+	if err := writeRole(b, storage, "long", "long.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	expectMaxTokenTTL(time.Hour)
+}
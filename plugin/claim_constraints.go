@@ -0,0 +1,215 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+)
+
+const keyClaimConstraints = "claim_constraints"
+
+// JSON types a claim can be constrained to.
+const (
+	ClaimTypeString  = "string"
+	ClaimTypeNumber  = "number"
+	ClaimTypeInteger = "integer"
+	ClaimTypeBoolean = "boolean"
+	ClaimTypeArray   = "array"
+	ClaimTypeObject  = "object"
+)
+
+var AllowedClaimTypes = []string{ClaimTypeString, ClaimTypeNumber, ClaimTypeInteger, ClaimTypeBoolean, ClaimTypeArray, ClaimTypeObject}
+
+// ClaimConstraint restricts the value of a claim provided during sign requests. The pattern, enum, min and max
+// rules apply to each element of array values.
+type ClaimConstraint struct {
+
+	// Type is the JSON type the value must have.
+	Type string `json:"type,omitempty"`
+
+	// Pattern is a regular expression (https://golang.org/pkg/regexp/) string values must match.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Enum is the list of allowed values.
+	Enum []interface{} `json:"enum,omitempty"`
+
+	// Min is the minimum of numeric values.
+	Min *float64 `json:"min,omitempty"`
+
+	// Max is the maximum of numeric values.
+	Max *float64 `json:"max,omitempty"`
+
+	// MaxItems is the maximum length of array values.
+	MaxItems *int `json:"max_items,omitempty"`
+}
+
+// parseClaimConstraints decodes and validates the claim constraints provided to a config or role.
+func parseClaimConstraints(rawConstraints map[string]interface{}) (map[string]*ClaimConstraint, error) {
+	constraints := make(map[string]*ClaimConstraint, len(rawConstraints))
+
+	for claim, rawConstraint := range rawConstraints {
+		if stringInSlice(claim, ReservedClaims) {
+			return nil, fmt.Errorf("'%s' claim is reserved and cannot be constrained", claim)
+		}
+
+		encodedConstraint, err := json.Marshal(rawConstraint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint for claim '%s': %w", claim, err)
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(encodedConstraint))
+		decoder.DisallowUnknownFields()
+
+		constraint := &ClaimConstraint{}
+		if err := decoder.Decode(constraint); err != nil {
+			return nil, fmt.Errorf("invalid constraint for claim '%s': %w", claim, err)
+		}
+
+		if constraint.Type != "" && !stringInSlice(constraint.Type, AllowedClaimTypes) {
+			return nil, fmt.Errorf("invalid 'type' constraint for claim '%s', must be one of %s", claim, AllowedClaimTypes)
+		}
+		if _, err := regexp.Compile(constraint.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid 'pattern' constraint for claim '%s': %w", claim, err)
+		}
+		if constraint.Min != nil && constraint.Max != nil && *constraint.Min > *constraint.Max {
+			return nil, fmt.Errorf("invalid constraint for claim '%s': 'min' is greater than 'max'", claim)
+		}
+		if constraint.MaxItems != nil && *constraint.MaxItems < 0 {
+			return nil, fmt.Errorf("invalid 'max_items' constraint for claim '%s': cannot be negative", claim)
+		}
+
+		constraints[claim] = constraint
+	}
+
+	return constraints, nil
+}
+
+// checkClaimConstraints checks each claim with a constraint satisfies it.
+func checkClaimConstraints(constraints map[string]*ClaimConstraint, claims map[string]interface{}) error {
+	for claim, value := range claims {
+		constraint, ok := constraints[claim]
+		if !ok {
+			continue
+		}
+		if err := constraint.check(claim, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check returns an error naming the claim and rule when the value doesn't satisfy the constraint.
+func (c *ClaimConstraint) check(claim string, rawValue interface{}) error {
+
+	value, err := normalizeClaimValue(rawValue)
+	if err != nil {
+		return fmt.Errorf("claim '%s' is not a JSON value: %w", claim, err)
+	}
+
+	if c.Type != "" && !claimHasType(value, c.Type) {
+		return fmt.Errorf("claim '%s' violates 'type' constraint: must be %s", claim, c.Type)
+	}
+
+	if items, ok := value.([]interface{}); ok {
+		if c.MaxItems != nil && len(items) > *c.MaxItems {
+			return fmt.Errorf("claim '%s' violates 'max_items' constraint: %d items exceeds %d", claim, len(items), *c.MaxItems)
+		}
+		for _, item := range items {
+			if err := c.checkValue(claim, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return c.checkValue(claim, value)
+}
+
+// checkValue checks the rules applying to a single, normalized, value.
+func (c *ClaimConstraint) checkValue(claim string, value interface{}) error {
+
+	if c.Pattern != "" {
+		if str, ok := value.(string); ok {
+			if matched, _ := regexp.MatchString(c.Pattern, str); !matched {
+				return fmt.Errorf("claim '%s' violates 'pattern' constraint: %q does not match", claim, str)
+			}
+		}
+	}
+
+	if len(c.Enum) > 0 {
+		allowed := false
+		for _, rawEnumValue := range c.Enum {
+			enumValue, err := normalizeClaimValue(rawEnumValue)
+			if err == nil && reflect.DeepEqual(enumValue, value) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("claim '%s' violates 'enum' constraint: %v is not allowed", claim, value)
+		}
+	}
+
+	if number, ok := value.(float64); ok {
+		if c.Min != nil && number < *c.Min {
+			return fmt.Errorf("claim '%s' violates 'min' constraint: %v is less than %v", claim, number, *c.Min)
+		}
+		if c.Max != nil && number > *c.Max {
+			return fmt.Errorf("claim '%s' violates 'max' constraint: %v is greater than %v", claim, number, *c.Max)
+		}
+	}
+
+	return nil
+}
+
+// normalizeClaimValue converts a value to its JSON decoded form, so values provided via the API and Go
+// values compare equal.
+func normalizeClaimValue(value interface{}) (interface{}, error) {
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalizedValue interface{}
+	if err := json.Unmarshal(encodedValue, &normalizedValue); err != nil {
+		return nil, err
+	}
+
+	return normalizedValue, nil
+}
+
+// claimHasType reports whether a normalized value has the JSON type.
+func claimHasType(value interface{}, claimType string) bool {
+	switch value := value.(type) {
+	case string:
+		return claimType == ClaimTypeString
+	case float64:
+		return claimType == ClaimTypeNumber || (claimType == ClaimTypeInteger && value == math.Trunc(value))
+	case bool:
+		return claimType == ClaimTypeBoolean
+	case []interface{}:
+		return claimType == ClaimTypeArray
+	case map[string]interface{}:
+		return claimType == ClaimTypeObject
+	}
+	return false
+}
@@ -18,10 +18,7 @@ package jwtsecrets
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/json"
-	"github.com/hashicorp/vault/sdk/helper/errutil"
-	"github.com/hashicorp/vault/sdk/helper/keysutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"gopkg.in/square/go-jose.v2"
 	"time"
@@ -39,6 +36,14 @@ const (
 	DefaultAudiencePattern    = ".*"
 	DefaultSubjectPattern     = ".*"
 	DefaultMaxAudiences       = -1
+	DefaultVerifyLeeway       = "0s"
+	DefaultKidFormat          = KeyIdFormatLegacy
+	DefaultJtiFormat          = JtiFormatFriendly
+	DefaultIssueLease         = true
+	DefaultJwksMaxAge         = "1h0m0s"
+
+	DefaultVerificationKeyRetention = "0s"
+	DefaultMinVerificationKeys      = 1
 )
 
 // DefaultAllowedClaims is the default value for the AllowedClaims config option.
@@ -48,7 +53,7 @@ var DefaultAllowedClaims = []string{"sub", "aud"}
 var ReservedClaims = []string{"iss", "exp", "nbf", "iat", "jti"}
 var ReservedHeaders = []string{"kid", "alg", "enc", "zip", "crit"}
 
-var AllowedSignatureAlgorithmNames = []string{string(jose.ES256), string(jose.ES384), string(jose.ES512), string(jose.RS256), string(jose.RS384), string(jose.RS512)}
+var AllowedSignatureAlgorithmNames = []string{string(jose.ES256), string(jose.ES384), string(jose.ES512), string(jose.RS256), string(jose.RS384), string(jose.RS512), string(jose.PS256), string(jose.PS384), string(jose.PS512), string(jose.EdDSA)}
 var AllowedRSAKeyBits = []int{2048, 3072, 4096}
 
 // Config holds all configuration for the backend.
@@ -62,18 +67,31 @@ type Config struct {
 	// KeyRotationPeriod is how frequently a new key is created.
 	KeyRotationPeriod time.Duration
 
+	// KeyPrepublishPeriod is how long a new key is published before it is used to sign new tokens.
+	KeyPrepublishPeriod time.Duration
+
 	// TokenTTL defines how long a token is valid for after being signed.
 	TokenTTL time.Duration
 
+	// MaxTokenTTL defines the maximum TTL callers can request for a token. If zero, TokenTTL is the maximum.
+	MaxTokenTTL time.Duration
+
 	// SetIat defines if the backend sets the 'iat' claim or not.
 	SetIAT bool
 
 	// SetJTI defines if the backend generates and sets the 'jti' claim or not.
 	SetJTI bool
 
+	// JtiFormat defines the format of generated 'jti' claims; one of AllowedJtiFormats.
+	JtiFormat string
+
 	// SetNBF defines if the backend sets the 'nbf' claim. If true, the claim will be set to the same as the 'iat' claim.
 	SetNBF bool
 
+	// IssueLease defines if a lease is created for each signed token. If nil, DefaultIssueLease is used, which
+	// applies to configurations saved before leases were optional.
+	IssueLease *bool
+
 	// AudiencePattern defines a regular expression (https://golang.org/pkg/regexp/) which must be matched by any incoming 'aud' claims.
 	// If the audience claim is an array, each element in the array must match the pattern.
 	AudiencePattern string
@@ -95,6 +113,31 @@ type Config struct {
 
 	// allowedHeadersMap is used to easily check if a header is in the allowed header set.
 	allowedHeadersMap map[string]bool
+
+	// ClaimConstraints restricts the values of claims provided during sign requests, by claim name.
+	ClaimConstraints map[string]*ClaimConstraint
+
+	// VerificationKeyRetention is how long a key version remains available for verification after it stops
+	// signing new tokens. Versions are always retained until all tokens signed with them have expired.
+	VerificationKeyRetention time.Duration
+
+	// MinVerificationKeys is the minimum number of key versions retained for verification, regardless of their age.
+	MinVerificationKeys int
+
+	// VerifyLeeway is the clock skew tolerated when validating the 'exp', 'nbf' and 'iat' claims of verified tokens.
+	VerifyLeeway time.Duration
+
+	// KidFormat defines how the 'kid' of each key version is created: KeyIdFormatLegacy, KeyIdFormatThumbprint or
+	// a template containing the {{version}} placeholder, and optionally the {{mount}} and {{key}} placeholders.
+	KidFormat string
+
+	// BaseURL is the externally reachable URL of the mount, used to advertise the JWKS in discovery documents.
+	BaseURL string
+
+	// JwksMaxAge is the maximum duration JWKS responses can be cached for. Responses are cacheable until the
+	// next scheduled rotation publishes a new key, bounded by this duration. If nil, DefaultJwksMaxAge is used,
+	// which applies to configurations saved before it was configurable.
+	JwksMaxAge *time.Duration
 }
 
 func (b *backend) getConfig(ctx context.Context, stg logical.Storage) (*Config, error) {
@@ -142,6 +185,28 @@ func (b *backend) getConfig(ctx context.Context, stg logical.Storage) (*Config,
 	return b.cachedConfig.copy(), nil
 }
 
+// maxTokenTTL returns the maximum TTL of tokens signed by the backend.
+func (c *Config) maxTokenTTL() time.Duration {
+	return durationMax(c.MaxTokenTTL, c.TokenTTL)
+}
+
+// issueLease returns whether a lease is created for each signed token.
+func (c *Config) issueLease() bool {
+	if c.IssueLease == nil {
+		return DefaultIssueLease
+	}
+	return *c.IssueLease
+}
+
+// jwksMaxAge returns the maximum duration JWKS responses can be cached for.
+func (c *Config) jwksMaxAge() time.Duration {
+	if c.JwksMaxAge == nil {
+		defaultJwksMaxAge, _ := time.ParseDuration(DefaultJwksMaxAge)
+		return defaultJwksMaxAge
+	}
+	return *c.JwksMaxAge
+}
+
 func (c *Config) copy() *Config {
 	cc := *c
 	return &cc
@@ -156,6 +221,8 @@ func (b *backend) saveConfig(ctx context.Context, stg logical.Storage, config *C
 			(config.SignatureAlgorithm != b.cachedConfig.SignatureAlgorithm ||
 				config.RSAKeyBits != b.cachedConfig.RSAKeyBits)
 
+	previousConfig := b.cachedConfig
+
 	if err := b.saveConfigUnlocked(ctx, stg, config); err != nil {
 		return err
 	}
@@ -164,45 +231,7 @@ func (b *backend) saveConfig(ctx context.Context, stg logical.Storage, config *C
 		return nil
 	}
 
-	b.Logger().Info("Key Format Rotation")
-
-	policy, err := b.getPolicy(ctx, stg, config, mount)
-	if err != nil {
-		return err
-	}
-
-	policy.Lock(true)
-	defer policy.Unlock()
-
-	switch config.SignatureAlgorithm {
-	case jose.RS256, jose.RS384, jose.RS512:
-		switch config.RSAKeyBits {
-		case 2048:
-			policy.Type = keysutil.KeyType_RSA2048
-		case 3072:
-			policy.Type = keysutil.KeyType_RSA3072
-		case 4096:
-			policy.Type = keysutil.KeyType_RSA4096
-		default:
-			err = errutil.InternalError{Err: "unsupported RSA key size"}
-		}
-	case jose.ES256:
-		policy.Type = keysutil.KeyType_ECDSA_P256
-	case jose.ES384:
-		policy.Type = keysutil.KeyType_ECDSA_P384
-	case jose.ES512:
-		policy.Type = keysutil.KeyType_ECDSA_P521
-	default:
-		err = errutil.InternalError{Err: "unknown/unsupported signature algorithm"}
-	}
-
-	if err != nil {
-		return nil
-	}
-
-	defer b.lockManager.InvalidatePolicy(mainKeyName)
-
-	return policy.Rotate(ctx, stg, rand.Reader)
+	return b.rotateKeyFormat(ctx, stg, mainKeyName, previousConfig.mainKey(), config.mainKey(), mount)
 }
 
 func (b *backend) saveConfigUnlocked(ctx context.Context, stg logical.Storage, config *Config) error {
@@ -237,6 +266,8 @@ func (b *backend) clearConfig(ctx context.Context, stg logical.Storage) error {
 func DefaultConfig(sys logical.SystemView) *Config {
 	defaultKeyRotationPeriod, _ := time.ParseDuration(DefaultKeyRotationPeriod)
 	defaultTokenTTL, _ := time.ParseDuration(DefaultTokenTTL)
+	defaultVerifyLeeway, _ := time.ParseDuration(DefaultVerifyLeeway)
+	defaultVerificationKeyRetention, _ := time.ParseDuration(DefaultVerificationKeyRetention)
 
 	c := &Config{}
 	c.SignatureAlgorithm = DefaultSignatureAlgorithm
@@ -250,10 +281,37 @@ func DefaultConfig(sys logical.SystemView) *Config {
 	c.SubjectPattern = DefaultSubjectPattern
 	c.MaxAudiences = DefaultMaxAudiences
 	c.AllowedClaims = DefaultAllowedClaims
+	c.VerificationKeyRetention = defaultVerificationKeyRetention
+	c.MinVerificationKeys = DefaultMinVerificationKeys
+	c.VerifyLeeway = defaultVerifyLeeway
+	c.KidFormat = DefaultKidFormat
+	c.JtiFormat = DefaultJtiFormat
 	return c
 }
 
+// mainKey returns the definition of the main signing key, which is defined by the config.
+func (c *Config) mainKey() *Key {
+	retention := c.VerificationKeyRetention
+	return &Key{
+		SignatureAlgorithm: c.SignatureAlgorithm,
+		RSAKeyBits:         c.RSAKeyBits,
+		RotationPeriod:     c.KeyRotationPeriod,
+		PrepublishPeriod:   c.KeyPrepublishPeriod,
+
+		VerificationKeyRetention: &retention,
+		MinVerificationKeys:      c.MinVerificationKeys,
+	}
+}
+
 func (c *Config) cache() *Config {
+	// Configurations saved before the key id format was configurable use legacy key ids
+	if c.KidFormat == "" {
+		c.KidFormat = KeyIdFormatLegacy
+	}
+	// Configurations saved before the jti format was configurable use friendly ids
+	if c.JtiFormat == "" {
+		c.JtiFormat = JtiFormatFriendly
+	}
 	c.allowedClaimsMap = makeAllowedClaimsMap(c.AllowedClaims)
 	c.allowedHeadersMap = makeAllowedClaimsMap(c.AllowedHeaders)
 	return c
@@ -0,0 +1,290 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"fmt"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"regexp"
+	"strings"
+)
+
+const identityTemplatePrefix = "identity."
+
+var identityTemplateRegex = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+// Values available to identity templates.
+const (
+	identityFieldID       = "id"
+	identityFieldName     = "name"
+	identityFieldMetadata = "metadata"
+	identityFieldNames    = "names"
+	identityFieldIDs      = "ids"
+)
+
+// identityTemplate selects a value from the identity of the caller, e.g. '{{identity.entity.metadata.team}}'.
+type identityTemplate struct {
+	// expr is the template expression, without braces.
+	expr string
+
+	// groups is set when the template selects from the groups of the entity, rather than the entity itself.
+	groups bool
+
+	// alias is the mount accessor of the entity alias the template selects from, if any.
+	alias string
+
+	// field is the selected field; one of id, name or metadata for entities and aliases, names or ids for groups.
+	field string
+
+	// metadataKey is the selected metadata key, if any. If empty, all metadata is selected.
+	metadataKey string
+}
+
+// callerIdentity is the entity, and the groups it belongs to, of the caller of a request.
+type callerIdentity struct {
+	entity *logical.Entity
+	groups []*logical.Group
+}
+
+// parseIdentityTemplate parses a template expression.
+func parseIdentityTemplate(expr string) (*identityTemplate, error) {
+	if !strings.HasPrefix(expr, identityTemplatePrefix) {
+		return nil, fmt.Errorf("unknown template '{{%s}}'", expr)
+	}
+
+	tmpl := &identityTemplate{expr: expr}
+	selector := strings.TrimPrefix(expr, identityTemplatePrefix)
+
+	switch {
+	case selector == "groups.names":
+		tmpl.groups = true
+		tmpl.field = identityFieldNames
+		return tmpl, nil
+
+	case selector == "groups.ids":
+		tmpl.groups = true
+		tmpl.field = identityFieldIDs
+		return tmpl, nil
+
+	case strings.HasPrefix(selector, "entity.aliases."):
+		split := strings.SplitN(strings.TrimPrefix(selector, "entity.aliases."), ".", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("invalid alias selector in template '{{%s}}'", expr)
+		}
+		tmpl.alias = split[0]
+		selector = split[1]
+
+	case strings.HasPrefix(selector, "entity."):
+		selector = strings.TrimPrefix(selector, "entity.")
+
+	default:
+		return nil, fmt.Errorf("unknown template '{{%s}}'", expr)
+	}
+
+	switch {
+	case selector == identityFieldID || selector == identityFieldName || selector == identityFieldMetadata:
+		tmpl.field = selector
+
+	case strings.HasPrefix(selector, identityFieldMetadata+".") && len(selector) > len(identityFieldMetadata)+1:
+		tmpl.field = identityFieldMetadata
+		tmpl.metadataKey = strings.TrimPrefix(selector, identityFieldMetadata+".")
+
+	default:
+		return nil, fmt.Errorf("unknown template '{{%s}}'", expr)
+	}
+
+	return tmpl, nil
+}
+
+// selectsString returns whether the template selects a string value.
+func (t *identityTemplate) selectsString() bool {
+	return !t.groups && (t.field != identityFieldMetadata || t.metadataKey != "")
+}
+
+// value returns the value selected by the template from the caller's identity. Groups are selected as arrays and
+// all metadata as an object; everything else is a string.
+func (t *identityTemplate) value(identity *callerIdentity) (interface{}, error) {
+	if t.groups {
+		values := make([]interface{}, 0, len(identity.groups))
+		for _, group := range identity.groups {
+			if t.field == identityFieldIDs {
+				values = append(values, group.ID)
+			} else {
+				values = append(values, group.Name)
+			}
+		}
+		return values, nil
+	}
+
+	id, name, metadata := identity.entity.ID, identity.entity.Name, identity.entity.Metadata
+
+	if t.alias != "" {
+		var alias *logical.Alias
+		for _, entityAlias := range identity.entity.Aliases {
+			if entityAlias.MountAccessor == t.alias {
+				alias = entityAlias
+				break
+			}
+		}
+		if alias == nil {
+			return nil, fmt.Errorf("no value for template '{{%s}}', entity has no alias for mount %s", t.expr, t.alias)
+		}
+		id, name, metadata = alias.ID, alias.Name, alias.Metadata
+	}
+
+	var value string
+	switch {
+	case t.field == identityFieldID:
+		value = id
+	case t.field == identityFieldName:
+		value = name
+	case t.metadataKey == "":
+		values := make(map[string]interface{}, len(metadata))
+		for key, metadataValue := range metadata {
+			values[key] = metadataValue
+		}
+		return values, nil
+	default:
+		value = metadata[t.metadataKey]
+	}
+
+	if value == "" {
+		return nil, fmt.Errorf("no value for template '{{%s}}'", t.expr)
+	}
+
+	return value, nil
+}
+
+// validateClaimTemplates checks that all templates in the claim value, and any values nested within it, are valid.
+func validateClaimTemplates(value interface{}) error {
+	_, err := walkClaimTemplates(value, func(tmpl *identityTemplate) (interface{}, error) {
+		if !tmpl.selectsString() {
+			return []interface{}{}, nil
+		}
+		return "", nil
+	})
+	return err
+}
+
+// hasClaimTemplates returns whether the claim value, or any values nested within it, contain templates.
+func hasClaimTemplates(value interface{}) bool {
+	found := false
+	_, _ = walkClaimTemplates(value, func(tmpl *identityTemplate) (interface{}, error) {
+		found = true
+		return "", nil
+	})
+	return found
+}
+
+// renderClaimTemplates replaces all templates in the claim value, and any values nested within it, with the
+// values they select from the caller's identity.
+func renderClaimTemplates(value interface{}, identity *callerIdentity) (interface{}, error) {
+	return walkClaimTemplates(value, func(tmpl *identityTemplate) (interface{}, error) {
+		return tmpl.value(identity)
+	})
+}
+
+// walkClaimTemplates returns a copy of the claim value with each template replaced by the result of render. A
+// string consisting of a single template is replaced by the value of any type; otherwise the templates are
+// interpolated into the string and must render to strings.
+func walkClaimTemplates(value interface{}, render func(*identityTemplate) (interface{}, error)) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		matches := identityTemplateRegex.FindAllStringSubmatchIndex(value, -1)
+		if len(matches) == 0 {
+			return value, nil
+		}
+
+		if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(value) {
+			tmpl, err := parseIdentityTemplate(value[matches[0][2]:matches[0][3]])
+			if err != nil {
+				return nil, err
+			}
+			return render(tmpl)
+		}
+
+		var rendered strings.Builder
+		last := 0
+		for _, match := range matches {
+			tmpl, err := parseIdentityTemplate(value[match[2]:match[3]])
+			if err != nil {
+				return nil, err
+			}
+			tmplValue, err := render(tmpl)
+			if err != nil {
+				return nil, err
+			}
+			tmplString, ok := tmplValue.(string)
+			if !ok {
+				return nil, fmt.Errorf("template '{{%s}}' is not a string, it must be the entire claim value", tmpl.expr)
+			}
+			rendered.WriteString(value[last:match[0]])
+			rendered.WriteString(tmplString)
+			last = match[1]
+		}
+		rendered.WriteString(value[last:])
+		return rendered.String(), nil
+
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			renderedItem, err := walkClaimTemplates(item, render)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = renderedItem
+		}
+		return rendered, nil
+
+	case []interface{}:
+		rendered := make([]interface{}, len(value))
+		for i, item := range value {
+			renderedItem, err := walkClaimTemplates(item, render)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = renderedItem
+		}
+		return rendered, nil
+
+	default:
+		return value, nil
+	}
+}
+
+// callerIdentity looks up the entity, and the groups it belongs to, of the caller of the request.
+func (b *backend) callerIdentity(req *logical.Request) (*callerIdentity, error) {
+	if req.EntityID == "" {
+		return nil, errutil.UserError{Err: "role requires the caller's identity, but the request has no entity"}
+	}
+
+	entity, err := b.System().EntityInfo(req.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if entity == nil {
+		return nil, errutil.UserError{Err: fmt.Sprintf("unknown entity %s", req.EntityID)}
+	}
+
+	groups, err := b.System().GroupsForEntity(req.EntityID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &callerIdentity{entity: entity, groups: groups}, nil
+}
@@ -0,0 +1,518 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaAnnotations are keywords that don't affect validation.
+var jsonSchemaAnnotations = []string{"$schema", "$id", "$comment", "title", "description", "default", "examples", "deprecated", "readOnly", "writeOnly"}
+
+// jsonSchema is a compiled JSON Schema supporting a subset of the draft 2020-12 validation vocabulary; references
+// and format assertions are not supported.
+type jsonSchema struct {
+	// boolean is set for the 'true' and 'false' schemas.
+	boolean *bool
+
+	types                []string
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	pattern              *regexp.Regexp
+	minLength            *int
+	maxLength            *int
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	multipleOf           *float64
+	minItems             *int
+	maxItems             *int
+	uniqueItems          bool
+	prefixItems          []*jsonSchema
+	items                *jsonSchema
+	contains             *jsonSchema
+	minProperties        *int
+	maxProperties        *int
+	required             []string
+	properties           map[string]*jsonSchema
+	patternProperties    map[*regexp.Regexp]*jsonSchema
+	additionalProperties *jsonSchema
+	allOf                []*jsonSchema
+	anyOf                []*jsonSchema
+	oneOf                []*jsonSchema
+	not                  *jsonSchema
+}
+
+// jsonSchemaError is an error in the schema located at the JSON pointer within the root schema.
+type jsonSchemaError struct {
+	pointer string
+	err     error
+}
+
+func (e *jsonSchemaError) Error() string {
+	return jsonPointerOrRoot(e.pointer) + ": " + e.err.Error()
+}
+
+// parseJSONSchema parses and compiles a JSON encoded schema.
+func parseJSONSchema(encodedSchema string) (*jsonSchema, error) {
+	var rawSchema interface{}
+	if err := json.Unmarshal([]byte(encodedSchema), &rawSchema); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if rawObject, ok := rawSchema.(map[string]interface{}); ok {
+		if dialect, ok := rawObject["$schema"]; ok && dialect != jsonSchemaDialect {
+			return nil, fmt.Errorf("unsupported '$schema' %v, must be %s", dialect, jsonSchemaDialect)
+		}
+	}
+
+	return compileJSONSchema(rawSchema, "")
+}
+
+// compileJSONSchema compiles a decoded schema located at the JSON pointer within the root schema.
+func compileJSONSchema(rawSchema interface{}, pointer string) (*jsonSchema, error) {
+
+	if boolean, ok := rawSchema.(bool); ok {
+		return &jsonSchema{boolean: &boolean}, nil
+	}
+
+	rawObject, ok := rawSchema.(map[string]interface{})
+	if !ok {
+		return nil, &jsonSchemaError{pointer: pointer, err: errors.New("schema must be an object or boolean")}
+	}
+
+	schema := &jsonSchema{}
+	var err error
+
+	for keyword, value := range rawObject {
+		keywordPointer := pointer + "/" + escapeJSONPointer(keyword)
+
+		switch keyword {
+		case "type":
+			schema.types, err = compileSchemaTypes(value)
+		case "enum":
+			enum, ok := value.([]interface{})
+			if !ok {
+				err = fmt.Errorf("must be an array")
+			}
+			schema.enum = enum
+		case "const":
+			schema.constValue, schema.hasConst = value, true
+		case "pattern":
+			var pattern string
+			if pattern, err = schemaString(value); err == nil {
+				schema.pattern, err = regexp.Compile(pattern)
+			}
+		case "minLength":
+			schema.minLength, err = schemaCount(value)
+		case "maxLength":
+			schema.maxLength, err = schemaCount(value)
+		case "minimum":
+			schema.minimum, err = schemaNumber(value)
+		case "maximum":
+			schema.maximum, err = schemaNumber(value)
+		case "exclusiveMinimum":
+			schema.exclusiveMinimum, err = schemaNumber(value)
+		case "exclusiveMaximum":
+			schema.exclusiveMaximum, err = schemaNumber(value)
+		case "multipleOf":
+			if schema.multipleOf, err = schemaNumber(value); err == nil && *schema.multipleOf <= 0 {
+				err = fmt.Errorf("must be greater than 0")
+			}
+		case "minItems":
+			schema.minItems, err = schemaCount(value)
+		case "maxItems":
+			schema.maxItems, err = schemaCount(value)
+		case "uniqueItems":
+			if schema.uniqueItems, ok = value.(bool); !ok {
+				err = fmt.Errorf("must be a boolean")
+			}
+		case "prefixItems":
+			schema.prefixItems, err = compileSchemaArray(value, keywordPointer)
+		case "items":
+			schema.items, err = compileJSONSchema(value, keywordPointer)
+		case "contains":
+			schema.contains, err = compileJSONSchema(value, keywordPointer)
+		case "minProperties":
+			schema.minProperties, err = schemaCount(value)
+		case "maxProperties":
+			schema.maxProperties, err = schemaCount(value)
+		case "required":
+			schema.required, err = schemaStrings(value)
+		case "properties":
+			schema.properties, err = compileSchemaMap(value, keywordPointer)
+		case "patternProperties":
+			var properties map[string]*jsonSchema
+			if properties, err = compileSchemaMap(value, keywordPointer); err == nil {
+				schema.patternProperties = map[*regexp.Regexp]*jsonSchema{}
+				for pattern, propertySchema := range properties {
+					var patternRegex *regexp.Regexp
+					if patternRegex, err = regexp.Compile(pattern); err != nil {
+						break
+					}
+					schema.patternProperties[patternRegex] = propertySchema
+				}
+			}
+		case "additionalProperties":
+			schema.additionalProperties, err = compileJSONSchema(value, keywordPointer)
+		case "allOf":
+			schema.allOf, err = compileSchemaArray(value, keywordPointer)
+		case "anyOf":
+			schema.anyOf, err = compileSchemaArray(value, keywordPointer)
+		case "oneOf":
+			schema.oneOf, err = compileSchemaArray(value, keywordPointer)
+		case "not":
+			schema.not, err = compileJSONSchema(value, keywordPointer)
+		default:
+			if !stringInSlice(keyword, jsonSchemaAnnotations) {
+				err = fmt.Errorf("unsupported keyword")
+			}
+		}
+
+		if err != nil {
+			// Errors from nested schemas already identify their location
+			var schemaErr *jsonSchemaError
+			if errors.As(err, &schemaErr) {
+				return nil, err
+			}
+			return nil, &jsonSchemaError{pointer: keywordPointer, err: err}
+		}
+	}
+
+	return schema, nil
+}
+
+func compileSchemaTypes(value interface{}) ([]string, error) {
+	types, err := schemaStrings(value)
+	if err != nil {
+		var single string
+		if single, err = schemaString(value); err != nil {
+			return nil, fmt.Errorf("must be a string or array of strings")
+		}
+		types = []string{single}
+	}
+
+	for _, schemaType := range types {
+		if schemaType != "null" && !stringInSlice(schemaType, AllowedClaimTypes) {
+			return nil, fmt.Errorf("unknown type %s", schemaType)
+		}
+	}
+
+	return types, nil
+}
+
+func compileSchemaArray(value interface{}, pointer string) ([]*jsonSchema, error) {
+	rawSchemas, ok := value.([]interface{})
+	if !ok || len(rawSchemas) == 0 {
+		return nil, fmt.Errorf("must be a non-empty array")
+	}
+
+	schemas := make([]*jsonSchema, len(rawSchemas))
+	for i, rawSchema := range rawSchemas {
+		schema, err := compileJSONSchema(rawSchema, pointer+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		schemas[i] = schema
+	}
+
+	return schemas, nil
+}
+
+func compileSchemaMap(value interface{}, pointer string) (map[string]*jsonSchema, error) {
+	rawSchemas, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an object")
+	}
+
+	schemas := make(map[string]*jsonSchema, len(rawSchemas))
+	for name, rawSchema := range rawSchemas {
+		schema, err := compileJSONSchema(rawSchema, pointer+"/"+escapeJSONPointer(name))
+		if err != nil {
+			return nil, err
+		}
+		schemas[name] = schema
+	}
+
+	return schemas, nil
+}
+
+func schemaString(value interface{}) (string, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("must be a string")
+	}
+	return str, nil
+}
+
+func schemaStrings(value interface{}) ([]string, error) {
+	rawStrings, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+
+	strs := make([]string, len(rawStrings))
+	for i, rawString := range rawStrings {
+		str, ok := rawString.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		strs[i] = str
+	}
+
+	return strs, nil
+}
+
+func schemaNumber(value interface{}) (*float64, error) {
+	number, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &number, nil
+}
+
+func schemaCount(value interface{}) (*int, error) {
+	number, ok := value.(float64)
+	if !ok || number < 0 || number != math.Trunc(number) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	count := int(number)
+	return &count, nil
+}
+
+// validate returns a description of each violation of the schema by the JSON decoded instance, prefixed
+// with the JSON pointer of the violating value. No violations are returned when the instance is valid.
+func (s *jsonSchema) validate(instance interface{}) []string {
+	violations := s.validateAt(instance, "")
+	sort.Strings(violations)
+	return violations
+}
+
+func (s *jsonSchema) validateAt(instance interface{}, pointer string) []string {
+
+	if s.boolean != nil {
+		if *s.boolean {
+			return nil
+		}
+		return []string{schemaViolation(pointer, "no value is allowed")}
+	}
+
+	var violations []string
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, schemaViolation(pointer, fmt.Sprintf(format, args...)))
+	}
+
+	if len(s.types) > 0 && !instanceHasSchemaType(instance, s.types) {
+		violate("expected %s", strings.Join(s.types, " or "))
+		return violations
+	}
+
+	if s.hasConst && !reflect.DeepEqual(s.constValue, instance) {
+		violate("must be %v", s.constValue)
+	}
+
+	if len(s.enum) > 0 {
+		allowed := false
+		for _, enumValue := range s.enum {
+			if reflect.DeepEqual(enumValue, instance) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			violate("%v is not one of the allowed values", instance)
+		}
+	}
+
+	switch value := instance.(type) {
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.minLength != nil && length < *s.minLength {
+			violate("length must be at least %d", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			violate("length must be at most %d", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			violate("does not match pattern %s", s.pattern)
+		}
+
+	case float64:
+		if s.minimum != nil && value < *s.minimum {
+			violate("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && value > *s.maximum {
+			violate("must be at most %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+			violate("must be greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+			violate("must be less than %v", *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil {
+			if quotient := value / *s.multipleOf; quotient != math.Trunc(quotient) {
+				violate("must be a multiple of %v", *s.multipleOf)
+			}
+		}
+
+	case []interface{}:
+		if s.minItems != nil && len(value) < *s.minItems {
+			violate("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			violate("must have at most %d items", *s.maxItems)
+		}
+		if s.uniqueItems {
+			for i := range value {
+				for j := i + 1; j < len(value); j++ {
+					if reflect.DeepEqual(value[i], value[j]) {
+						violate("items %d and %d are equal", i, j)
+					}
+				}
+			}
+		}
+		for i, item := range value {
+			itemPointer := pointer + "/" + strconv.Itoa(i)
+			if i < len(s.prefixItems) {
+				violations = append(violations, s.prefixItems[i].validateAt(item, itemPointer)...)
+			} else if s.items != nil {
+				violations = append(violations, s.items.validateAt(item, itemPointer)...)
+			}
+		}
+		if s.contains != nil {
+			contained := false
+			for i, item := range value {
+				if len(s.contains.validateAt(item, pointer+"/"+strconv.Itoa(i))) == 0 {
+					contained = true
+					break
+				}
+			}
+			if !contained {
+				violate("no item matches 'contains'")
+			}
+		}
+
+	case map[string]interface{}:
+		if s.minProperties != nil && len(value) < *s.minProperties {
+			violate("must have at least %d properties", *s.minProperties)
+		}
+		if s.maxProperties != nil && len(value) > *s.maxProperties {
+			violate("must have at most %d properties", *s.maxProperties)
+		}
+		for _, name := range s.required {
+			if _, ok := value[name]; !ok {
+				violations = append(violations, schemaViolation(pointer+"/"+escapeJSONPointer(name), "is required"))
+			}
+		}
+		for name, property := range value {
+			propertyPointer := pointer + "/" + escapeJSONPointer(name)
+			evaluated := false
+			if propertySchema, ok := s.properties[name]; ok {
+				evaluated = true
+				violations = append(violations, propertySchema.validateAt(property, propertyPointer)...)
+			}
+			for pattern, propertySchema := range s.patternProperties {
+				if pattern.MatchString(name) {
+					evaluated = true
+					violations = append(violations, propertySchema.validateAt(property, propertyPointer)...)
+				}
+			}
+			if !evaluated && s.additionalProperties != nil {
+				violations = append(violations, s.additionalProperties.validateAt(property, propertyPointer)...)
+			}
+		}
+	}
+
+	for _, subschema := range s.allOf {
+		violations = append(violations, subschema.validateAt(instance, pointer)...)
+	}
+
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, subschema := range s.anyOf {
+			if len(subschema.validateAt(instance, pointer)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			violate("does not match any schema of 'anyOf'")
+		}
+	}
+
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, subschema := range s.oneOf {
+			if len(subschema.validateAt(instance, pointer)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			violate("matches %d schemas of 'oneOf', expected exactly 1", matches)
+		}
+	}
+
+	if s.not != nil && len(s.not.validateAt(instance, pointer)) == 0 {
+		violate("must not match the 'not' schema")
+	}
+
+	return violations
+}
+
+// instanceHasSchemaType reports whether a JSON decoded instance has one of the schema types.
+func instanceHasSchemaType(instance interface{}, types []string) bool {
+	for _, schemaType := range types {
+		if schemaType == "null" && instance == nil {
+			return true
+		}
+		if claimHasType(instance, schemaType) {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaViolation(pointer string, message string) string {
+	return jsonPointerOrRoot(pointer) + ": " + message
+}
+
+// jsonPointerOrRoot returns the pointer, or '/' for the empty pointer to the root value so it remains readable.
+func jsonPointerOrRoot(pointer string) string {
+	if pointer == "" {
+		return "/"
+	}
+	return pointer
+}
+
+// escapeJSONPointer escapes a reference token of a JSON pointer (RFC 6901).
+func escapeJSONPointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
@@ -0,0 +1,61 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"encoding/json"
+	"github.com/go-test/deep"
+	"testing"
+)
+
+func TestJSONSchemaValidate(t *testing.T) {
+	tests := []struct {
+		schema     string
+		instance   string
+		violations []string
+	}{
+		{`true`, `{"a": 1}`, nil},
+		{`false`, `1`, []string{"/: no value is allowed"}},
+		{`{"type": ["string", "null"]}`, `null`, nil},
+		{`{"type": "integer"}`, `1.5`, []string{"/: expected integer"}},
+		{`{"minLength": 2, "maxLength": 3}`, `"ü"`, []string{"/: length must be at least 2"}},
+		{`{"exclusiveMinimum": 0, "maximum": 10, "multipleOf": 5}`, `12`, []string{"/: must be a multiple of 5", "/: must be at most 10"}},
+		{`{"prefixItems": [{"type": "string"}], "items": {"type": "number"}}`, `["a", 1, "b"]`, []string{"/2: expected number"}},
+		{`{"uniqueItems": true, "contains": {"const": "x"}}`, `["a", "a"]`, []string{"/: items 0 and 1 are equal", "/: no item matches 'contains'"}},
+		{`{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": false}`, `{"x-a": "b", "c/d": 1}`, []string{"/c~1d: no value is allowed"}},
+		{`{"minProperties": 1, "required": ["a~b"]}`, `{}`, []string{"/: must have at least 1 properties", "/a~0b: is required"}},
+		{`{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `true`, []string{"/: does not match any schema of 'anyOf'"}},
+		{`{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, []string{"/: matches 2 schemas of 'oneOf', expected exactly 1"}},
+		{`{"allOf": [{"minimum": 1}], "not": {"const": 2}}`, `2`, []string{"/: must not match the 'not' schema"}},
+	}
+
+	for _, test := range tests {
+		schema, err := parseJSONSchema(test.schema)
+		if err != nil {
+			t.Fatalf("schema %s: %v", test.schema, err)
+		}
+
+		var instance interface{}
+		if err := json.Unmarshal([]byte(test.instance), &instance); err != nil {
+			t.Fatalf("instance %s: %v", test.instance, err)
+		}
+
+		if diff := deep.Equal(test.violations, schema.validate(instance)); diff != nil {
+			t.Errorf("schema %s, instance %s: %v", test.schema, test.instance, diff)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mariuszs/friendlyid-go/friendlyid"
+	"time"
+)
+
+// Formats of generated 'jti' claims.
+const (
+	// JtiFormatFriendly is a random (version 4) UUID, encoded as a friendly-id.
+	JtiFormatFriendly = "friendly"
+
+	// JtiFormatUUID is a random (version 4) UUID.
+	JtiFormatUUID = "uuid"
+
+	// JtiFormatUUIDv7 is a time-ordered (version 7) UUID, sortable by millisecond.
+	JtiFormatUUIDv7 = "uuidv7"
+
+	// JtiFormatULID is a ULID (https://github.com/ulid/spec), sortable by millisecond.
+	JtiFormatULID = "ulid"
+
+	// JtiFormatRandom is 128 random bits, encoded as unpadded base64url.
+	JtiFormatRandom = "random"
+)
+
+var AllowedJtiFormats = []string{JtiFormatFriendly, JtiFormatUUID, JtiFormatUUIDv7, JtiFormatULID, JtiFormatRandom}
+
+// crockfordBase32 is the alphabet used to encode ULIDs.
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// jtiGenerator generates unique ids in each of the allowed jti formats.
+type jtiGenerator struct{}
+
+func (g jtiGenerator) id(format string) (string, error) {
+	switch format {
+	case JtiFormatFriendly:
+		generatedUUID, err := uuid.NewRandom()
+		if err != nil {
+			return "", err
+		}
+		return friendlyid.Encode(generatedUUID.String())
+
+	case JtiFormatUUID:
+		generatedUUID, err := uuid.NewRandom()
+		if err != nil {
+			return "", err
+		}
+		return generatedUUID.String(), nil
+
+	case JtiFormatUUIDv7:
+		var generatedUUID uuid.UUID
+		if err := timestampedRandom(generatedUUID[:]); err != nil {
+			return "", err
+		}
+		generatedUUID[6] = (generatedUUID[6] & 0x0f) | 0x70 // version 7
+		generatedUUID[8] = (generatedUUID[8] & 0x3f) | 0x80 // RFC 4122 variant
+		return generatedUUID.String(), nil
+
+	case JtiFormatULID:
+		var ulid [16]byte
+		if err := timestampedRandom(ulid[:]); err != nil {
+			return "", err
+		}
+		return encodeULID(ulid), nil
+
+	case JtiFormatRandom:
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(random), nil
+
+	default:
+		return "", fmt.Errorf("unsupported jti format %s", format)
+	}
+}
+
+// timestampedRandom fills the id with the current unix time in milliseconds, as a 48-bit big-endian integer,
+// followed by random bytes.
+func timestampedRandom(id []byte) error {
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(time.Now().UnixMilli()))
+	copy(id[:6], timestamp[2:])
+
+	_, err := rand.Read(id[6:])
+	return err
+}
+
+// encodeULID encodes the 128-bit ULID as 26 characters of Crockford's base32.
+func encodeULID(ulid [16]byte) string {
+	hi := binary.BigEndian.Uint64(ulid[:8])
+	lo := binary.BigEndian.Uint64(ulid[8:])
+
+	encoded := make([]byte, 26)
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = crockfordBase32[lo&0x1f]
+		lo = (lo >> 5) | (hi << 59)
+		hi >>= 5
+	}
+
+	return string(encoded)
+}
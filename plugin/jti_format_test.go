@@ -0,0 +1,105 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"github.com/go-test/deep"
+	"github.com/google/uuid"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestJtiFormats(t *testing.T) {
+	patterns := map[string]*regexp.Regexp{
+		JtiFormatFriendly: regexp.MustCompile(`^[0-9A-Za-z]{1,22}$`),
+		JtiFormatUUID:     regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		JtiFormatUUIDv7:   regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		JtiFormatULID:     regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+		JtiFormatRandom:   regexp.MustCompile(`^[0-9A-Za-z_-]{22}$`),
+	}
+
+	for _, format := range AllowedJtiFormats {
+		first, err := jtiGenerator{}.id(format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !patterns[format].MatchString(first) {
+			t.Errorf("%s: unexpected id %s", format, first)
+		}
+
+		second, err := jtiGenerator{}.id(format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if first == second {
+			t.Errorf("%s: ids should be unique", format)
+		}
+	}
+
+	if _, err := (jtiGenerator{}).id("uuidv1"); err == nil {
+		t.Error("unknown format should have failed")
+	}
+}
+
+func TestJtiFormatsSortable(t *testing.T) {
+	for _, format := range []string{JtiFormatUUIDv7, JtiFormatULID} {
+		first, err := jtiGenerator{}.id(format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		time.Sleep(2 * time.Millisecond)
+
+		second, err := jtiGenerator{}.id(format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		if first >= second {
+			t.Errorf("%s: %s should sort before %s", format, first, second)
+		}
+	}
+
+	id, err := jtiGenerator{}.id(JtiFormatUUIDv7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(uuid.Version(7), uuid.MustParse(id).Version()); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestEncodeULID(t *testing.T) {
+	var ulid [16]byte
+	if diff := deep.Equal("00000000000000000000000000", encodeULID(ulid)); diff != nil {
+		t.Error(diff)
+	}
+
+	for i := range ulid {
+		ulid[i] = 0xff
+	}
+	if diff := deep.Equal("7ZZZZZZZZZZZZZZZZZZZZZZZZZ", encodeULID(ulid)); diff != nil {
+		t.Error(diff)
+	}
+
+	// Timestamp 1469918176385 from the ULID specification
+	ulid = [16]byte{0x01, 0x56, 0x3d, 0xf3, 0x64, 0x81}
+	if diff := deep.Equal("01ARYZ6S41", encodeULID(ulid)[:10]); diff != nil {
+		t.Error(diff)
+	}
+}
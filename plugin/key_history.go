@@ -0,0 +1,94 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"path"
+)
+
+const keyHistoryPath = "key-history"
+
+// keyFormat records the signature algorithm used by key versions prior to a key format change.
+type keyFormat struct {
+	// LastVersion is the latest key version generated for SignatureAlgorithm.
+	LastVersion int
+
+	// SignatureAlgorithm is the algorithm used to sign with versions up to and including LastVersion.
+	SignatureAlgorithm jose.SignatureAlgorithm
+}
+
+// keyHistory is the ordered list of key formats used by a policy before its current format.
+type keyHistory []keyFormat
+
+// algorithm returns the signature algorithm recorded for the key version, if any.
+func (h keyHistory) algorithm(version int) (jose.SignatureAlgorithm, bool) {
+	for _, format := range h {
+		if version <= format.LastVersion {
+			return format.SignatureAlgorithm, true
+		}
+	}
+	return "", false
+}
+
+// minCurrentVersion returns the earliest key version generated in the current key format.
+func (h keyHistory) minCurrentVersion() int {
+	if len(h) == 0 {
+		return 1
+	}
+	return h[len(h)-1].LastVersion + 1
+}
+
+func (b *backend) getKeyHistory(ctx context.Context, stg logical.Storage, policyName string) (keyHistory, error) {
+	entry, err := stg.Get(ctx, path.Join(keyHistoryPath, policyName))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var history keyHistory
+	if err := entry.DecodeJSON(&history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// recordKeyFormat records that versions up to lastVersion were generated for the signature algorithm.
+func (b *backend) recordKeyFormat(ctx context.Context, stg logical.Storage, policyName string, lastVersion int, sigAlg jose.SignatureAlgorithm) error {
+	history, err := b.getKeyHistory(ctx, stg, policyName)
+	if err != nil {
+		return err
+	}
+
+	history = append(history, keyFormat{
+		LastVersion:        lastVersion,
+		SignatureAlgorithm: sigAlg,
+	})
+
+	entry, err := logical.StorageEntryJSON(path.Join(keyHistoryPath, policyName), history)
+	if err != nil {
+		return err
+	}
+
+	return stg.Put(ctx, entry)
+}
@@ -0,0 +1,250 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Key id formats; any other format is a template.
+const (
+	// KeyIdFormatLegacy derives key ids from the key id namespace, key name and version.
+	KeyIdFormatLegacy = "legacy"
+
+	// KeyIdFormatThumbprint uses the RFC 7638 SHA-256 thumbprint of the public key as its key id.
+	KeyIdFormatThumbprint = "thumbprint"
+)
+
+const keyIdHistoryPath = "key-id-history"
+
+// Placeholders replaced in key id templates.
+const (
+	keyIdTemplateMount   = "{{mount}}"
+	keyIdTemplateKey     = "{{key}}"
+	keyIdTemplateVersion = "{{version}}"
+)
+
+var keyIdTemplatePlaceholderRegex = regexp.MustCompile(`{{[^{}]*}}`)
+
+// validateKeyIdFormat checks the format is a known format or a template that produces a distinct key id
+// for each version of a key.
+func validateKeyIdFormat(format string) error {
+	switch format {
+	case KeyIdFormatLegacy, KeyIdFormatThumbprint:
+		return nil
+	}
+
+	for _, placeholder := range keyIdTemplatePlaceholderRegex.FindAllString(format, -1) {
+		switch placeholder {
+		case keyIdTemplateMount, keyIdTemplateKey, keyIdTemplateVersion:
+		default:
+			return fmt.Errorf("unknown placeholder %s, must be one of %s", placeholder, []string{keyIdTemplateMount, keyIdTemplateKey, keyIdTemplateVersion})
+		}
+	}
+
+	if !strings.Contains(format, keyIdTemplateVersion) {
+		return fmt.Errorf("must be one of %s or a template containing %s", []string{KeyIdFormatLegacy, KeyIdFormatThumbprint}, keyIdTemplateVersion)
+	}
+
+	return nil
+}
+
+// keyIdFormatDistinguishesKeys reports whether key ids of the format are distinct across signing keys.
+func keyIdFormatDistinguishesKeys(format string) bool {
+	switch format {
+	case KeyIdFormatLegacy, KeyIdFormatThumbprint:
+		return true
+	}
+	return strings.Contains(format, keyIdTemplateKey)
+}
+
+// keyIdFormatChange records the key id format used by a policy's versions prior to a key id format change.
+type keyIdFormatChange struct {
+	// LastVersion is the latest key version created while Format was configured.
+	LastVersion int
+
+	// Format is the key id format of versions up to and including LastVersion.
+	Format string
+}
+
+// keyIdHistory is the ordered list of key id formats used by a policy before the configured format.
+type keyIdHistory []keyIdFormatChange
+
+// format returns the key id format recorded for the key version, if any.
+func (h keyIdHistory) format(version int) (string, bool) {
+	for _, change := range h {
+		if version <= change.LastVersion {
+			return change.Format, true
+		}
+	}
+	return "", false
+}
+
+func (b *backend) getKeyIdHistory(ctx context.Context, stg logical.Storage, policyName string) (keyIdHistory, error) {
+	entry, err := stg.Get(ctx, path.Join(keyIdHistoryPath, policyName))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var history keyIdHistory
+	if err := entry.DecodeJSON(&history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// recordKeyIdFormat records that the existing versions of every signing key use the previous key id format,
+// so only versions created after a key id format change use the new format.
+func (b *backend) recordKeyIdFormat(ctx context.Context, stg logical.Storage, previousFormat string) error {
+	keyNames, err := b.listKeyNames(ctx, stg)
+	if err != nil {
+		return err
+	}
+
+	for _, keyName := range keyNames {
+		policy, _, err := b.lockManager.GetPolicy(ctx, keysutil.PolicyRequest{Storage: stg, Name: keyName}, nil)
+		if err != nil {
+			return err
+		}
+		if policy == nil {
+			continue
+		}
+
+		policy.Lock(false)
+		latestVersion := policy.LatestVersion
+		policy.Unlock()
+
+		history, err := b.getKeyIdHistory(ctx, stg, keyName)
+		if err != nil {
+			return err
+		}
+
+		// Versions already recorded keep the format they were created with
+		if len(history) > 0 && history[len(history)-1].LastVersion >= latestVersion {
+			continue
+		}
+
+		history = append(history, keyIdFormatChange{
+			LastVersion: latestVersion,
+			Format:      previousFormat,
+		})
+
+		entry, err := logical.StorageEntryJSON(path.Join(keyIdHistoryPath, keyName), history)
+		if err != nil {
+			return err
+		}
+
+		if err := stg.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyIdFormatter creates the key ids of policy key versions.
+type keyIdFormatter struct {
+	// Format is the configured key id format.
+	Format string
+
+	// History is the key id formats of versions created before the configured format, by key name.
+	History map[string]keyIdHistory
+
+	// Namespace is the key id namespace legacy key ids are derived from.
+	Namespace string
+
+	// Mount is the mount point of the backend.
+	Mount string
+}
+
+func (b *backend) keyIdFormatter(ctx context.Context, stg logical.Storage, config *Config, mount string) (*keyIdFormatter, error) {
+	keyIdNamespace, err := b.getKeyIdNamespace(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	keyNames, err := b.listKeyNames(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	history := map[string]keyIdHistory{}
+	for _, keyName := range keyNames {
+		history[keyName], err = b.getKeyIdHistory(ctx, stg, keyName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &keyIdFormatter{
+		Format:    config.KidFormat,
+		History:   history,
+		Namespace: keyIdNamespace,
+		Mount:     mount,
+	}, nil
+}
+
+// keyId returns the key id of a policy key version, in the format the version was created with. The key
+// entry is only required for thumbprint key ids and may be nil when the version is no longer available.
+func (f *keyIdFormatter) keyId(policyName string, version int, entry *keysutil.KeyEntry) (string, error) {
+	format, ok := f.History[policyName].format(version)
+	if !ok {
+		format = f.Format
+	}
+
+	switch format {
+	case KeyIdFormatLegacy:
+		return createKeyId(f.Namespace, policyName, version), nil
+	case KeyIdFormatThumbprint:
+		if entry == nil {
+			return "", fmt.Errorf("key version %d is not available", version)
+		}
+
+		publicKey, err := versionPublicKey(*entry)
+		if err != nil {
+			return "", err
+		}
+
+		thumbprint, err := (&jose.JSONWebKey{Key: publicKey}).Thumbprint(crypto.SHA256)
+		if err != nil {
+			return "", err
+		}
+
+		return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+	}
+
+	return strings.NewReplacer(
+		keyIdTemplateMount, strings.Trim(f.Mount, "/"),
+		keyIdTemplateKey, policyName,
+		keyIdTemplateVersion, strconv.Itoa(version),
+	).Replace(format), nil
+}
@@ -0,0 +1,65 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const keyIdNamespacePath = "key-id-namespace"
+
+// getKeyIdNamespace returns the namespace key ids are derived from. It defaults to the backend's id
+// and is replaced when restoring a backup, so restored keys keep their key ids.
+func (b *backend) getKeyIdNamespace(ctx context.Context, stg logical.Storage) (string, error) {
+	b.cachedKeyIdNamespaceLock.RLock()
+	if b.cachedKeyIdNamespace != "" {
+		defer b.cachedKeyIdNamespaceLock.RUnlock()
+		return b.cachedKeyIdNamespace, nil
+	}
+
+	b.cachedKeyIdNamespaceLock.RUnlock()
+	b.cachedKeyIdNamespaceLock.Lock()
+	defer b.cachedKeyIdNamespaceLock.Unlock()
+
+	entry, err := stg.Get(ctx, keyIdNamespacePath)
+	if err != nil {
+		return "", err
+	}
+
+	if entry != nil {
+		b.cachedKeyIdNamespace = string(entry.Value)
+	} else {
+		b.cachedKeyIdNamespace = b.id
+	}
+
+	return b.cachedKeyIdNamespace, nil
+}
+
+// setKeyIdNamespace replaces the namespace key ids are derived from.
+func (b *backend) setKeyIdNamespace(ctx context.Context, stg logical.Storage, namespace string) error {
+	b.cachedKeyIdNamespaceLock.Lock()
+	defer b.cachedKeyIdNamespaceLock.Unlock()
+
+	if err := stg.Put(ctx, &logical.StorageEntry{Key: keyIdNamespacePath, Value: []byte(namespace)}); err != nil {
+		return err
+	}
+
+	b.cachedKeyIdNamespace = namespace
+
+	return nil
+}
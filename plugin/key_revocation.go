@@ -0,0 +1,157 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"path"
+	"strconv"
+	"time"
+)
+
+const keyRevocationsPath = "key-revocations"
+
+// keyRevocation records the revocation of a compromised key version.
+type keyRevocation struct {
+	// Version is the revoked key version.
+	Version int
+
+	// RevokedAt is when the version was revoked.
+	RevokedAt time.Time
+
+	// RevokedBy is the display name of the token that revoked the version.
+	RevokedBy string
+
+	// EntityID is the identity entity that revoked the version, if any.
+	EntityID string
+
+	// Reason is the operator supplied reason for the revocation.
+	Reason string
+}
+
+// keyRevocations is the list of revoked versions of a policy, in order of revocation.
+type keyRevocations []keyRevocation
+
+// find returns the revocation of the key version, if any.
+func (r keyRevocations) find(version int) (*keyRevocation, bool) {
+	for i := range r {
+		if r[i].Version == version {
+			return &r[i], true
+		}
+	}
+	return nil, false
+}
+
+// minSigningVersion returns the earliest key version that can sign after all revocations.
+func (r keyRevocations) minSigningVersion() int {
+	minVersion := 1
+	for _, revocation := range r {
+		minVersion = intMax(minVersion, revocation.Version+1)
+	}
+	return minVersion
+}
+
+func (b *backend) getKeyRevocations(ctx context.Context, stg logical.Storage, policyName string) (keyRevocations, error) {
+	entry, err := stg.Get(ctx, path.Join(keyRevocationsPath, policyName))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var revocations keyRevocations
+	if err := entry.DecodeJSON(&revocations); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// recordKeyRevocation records that a key version has been revoked.
+func (b *backend) recordKeyRevocation(ctx context.Context, stg logical.Storage, policyName string, revocation keyRevocation) error {
+	revocations, err := b.getKeyRevocations(ctx, stg, policyName)
+	if err != nil {
+		return err
+	}
+
+	revocations = append(revocations, revocation)
+
+	entry, err := logical.StorageEntryJSON(path.Join(keyRevocationsPath, policyName), revocations)
+	if err != nil {
+		return err
+	}
+
+	return stg.Put(ctx, entry)
+}
+
+// isRevokedKeyId reports whether the key id references a revoked version of any signing key.
+func (b *backend) isRevokedKeyId(ctx context.Context, stg logical.Storage, keyIds *keyIdFormatter, kid string) (bool, error) {
+	keyNames, err := b.listKeyNames(ctx, stg)
+	if err != nil {
+		return false, err
+	}
+
+	for _, keyName := range keyNames {
+		revocations, err := b.getKeyRevocations(ctx, stg, keyName)
+		if err != nil {
+			return false, err
+		}
+		if len(revocations) == 0 {
+			continue
+		}
+
+		policy, _, err := b.lockManager.GetPolicy(ctx, keysutil.PolicyRequest{Storage: stg, Name: keyName}, nil)
+		if err != nil {
+			return false, err
+		}
+
+		if revokedKeyIdInPolicy(policy, keyName, revocations, keyIds, kid) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// revokedKeyIdInPolicy reports whether the key id references one of the revoked versions of the policy.
+// Versions that are no longer available are only matched when their key id doesn't depend on the key itself.
+func revokedKeyIdInPolicy(policy *keysutil.Policy, keyName string, revocations keyRevocations, keyIds *keyIdFormatter, kid string) bool {
+	if policy != nil {
+		policy.Lock(false)
+		defer policy.Unlock()
+	}
+
+	for _, revocation := range revocations {
+		var keyEntry *keysutil.KeyEntry
+		if policy != nil {
+			if entry, ok := policy.Keys[strconv.Itoa(revocation.Version)]; ok {
+				keyEntry = &entry
+			}
+		}
+
+		revokedKid, err := keyIds.keyId(keyName, revocation.Version, keyEntry)
+		if err == nil && revokedKid == kid {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,150 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strconv"
+	"time"
+)
+
+const (
+	keyLatestVersion  = "latest_version"
+	keyImported       = "imported"
+	keyVersions       = "versions"
+	keyKeyID          = "kid"
+	keyAlgorithm      = "algorithm"
+	keyStatus         = "status"
+	keyCreationTime   = "creation_time"
+	keyRotationTime   = "rotation_time"
+	keyExpiryTime     = "expiry_time"
+	keyPublicKey      = "public_key"
+	keyRevocationInfo = "revocation"
+)
+
+// Lifecycle statuses of a key version.
+const (
+	KeyStatusPrepublished = "prepublished"
+	KeyStatusSigning      = "signing"
+	KeyStatusVerifyOnly   = "verify_only"
+	KeyStatusPendingPrune = "pending_prune"
+	KeyStatusRevoked      = "revoked"
+)
+
+// keyVersions returns the lifecycle metadata of each available version of a key's policy.
+func (b *backend) keyVersions(ctx context.Context, stg logical.Storage, key *Key, policy *keysutil.Policy, config *Config, mount string) (map[string]interface{}, error) {
+
+	signer, err := b.policySigner(ctx, stg, key, policy, mount)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := b.getKeyHistory(ctx, stg, policy.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	revocations, err := b.getKeyRevocations(ctx, stg, policy.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	keyIds, err := b.keyIdFormatter(ctx, stg, config, mount)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenTTL, err := b.maxTokenTTL(ctx, stg, config)
+	if err != nil {
+		return nil, err
+	}
+
+	policy.Lock(false)
+	defer policy.Unlock()
+
+	signingVersion := signer.signingVersion()
+	retainedVersion := key.minRetainedVersion(policy.LatestVersion)
+	now := time.Now()
+
+	versions := map[string]interface{}{}
+
+	for version := policy.MinDecryptionVersion; version <= policy.LatestVersion; version++ {
+
+		keyVersion, ok := policy.Keys[strconv.Itoa(version)]
+		if !ok {
+			continue
+		}
+
+		rotatesAt := key.versionRotatesAt(keyVersion.CreationTime)
+		expiresAt := key.versionExpiresAt(keyVersion.CreationTime, tokenTTL)
+
+		versionData := map[string]interface{}{
+			keyCreationTime: keyVersion.CreationTime.Format(time.RFC3339),
+			keyRotationTime: rotatesAt.Format(time.RFC3339),
+			keyExpiryTime:   expiresAt.Format(time.RFC3339),
+		}
+
+		if kid, err := keyIds.keyId(policy.Name, version, &keyVersion); err != nil {
+			b.Logger().Warn(fmt.Sprintf("Unable to create key id: mount=%s, key=%s, version=%d, error=%s", mount, policy.Name, version, err))
+		} else {
+			versionData[keyKeyID] = kid
+		}
+
+		revocation, revoked := revocations.find(version)
+
+		switch {
+		case revoked:
+			versionData[keyStatus] = KeyStatusRevoked
+			versionData[keyRevocationInfo] = revocation.toResponseData()
+		case version > signingVersion:
+			versionData[keyStatus] = KeyStatusPrepublished
+		case version == signingVersion:
+			versionData[keyStatus] = KeyStatusSigning
+		case version < retainedVersion && !expiresAt.After(now):
+			versionData[keyStatus] = KeyStatusPendingPrune
+		default:
+			versionData[keyStatus] = KeyStatusVerifyOnly
+		}
+
+		publicKey, err := versionPublicKey(keyVersion)
+		if err != nil {
+			b.Logger().Warn(fmt.Sprintf("Unable to read public key: mount=%s, key=%s, version=%d, error=%s", mount, policy.Name, version, err))
+		} else {
+			derKey, err := x509.MarshalPKIXPublicKey(publicKey)
+			if err != nil {
+				return nil, err
+			}
+
+			versionData[keyPublicKey] = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: derKey}))
+
+			sigAlg, ok := history.algorithm(version)
+			if !ok {
+				sigAlg = versionAlgorithm(publicKey, key.SignatureAlgorithm)
+			}
+			versionData[keyAlgorithm] = string(sigAlg)
+		}
+
+		versions[strconv.Itoa(version)] = versionData
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,639 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	keyBackup = "backup"
+
+	minBackupPublicKeyBits = 2048
+)
+
+// backupData holds everything required to restore a mount's keys, configuration, roles, issuers and the
+// revocations of unexpired tokens.
+type backupData struct {
+	KeyIdNamespace string                    `json:"key_id_namespace"`
+	Config         *Config                   `json:"config"`
+	Roles          map[string]*Role          `json:"roles"`
+	Issuers        map[string]*Issuer        `json:"issuers"`
+	Keys           map[string]*Key           `json:"keys"`
+	Policies       map[string]string         `json:"policies"`
+	KeyHistory     map[string]keyHistory     `json:"key_history"`
+	KeyRevocations map[string]keyRevocations `json:"key_revocations"`
+	KeyIdHistory   map[string]keyIdHistory   `json:"key_id_history"`
+
+	TokenRevocations []*tokenRevocation `json:"token_revocations"`
+}
+
+func pathBackup(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "backup",
+			Fields: map[string]*framework.FieldSchema{
+				keyPublicKey: {
+					Type:        framework.TypeString,
+					Description: `PEM encoded RSA public key the backup is encrypted to.`,
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathBackupWrite,
+				},
+			},
+			HelpSynopsis:    pathBackupHelpSyn,
+			HelpDescription: pathBackupHelpDesc,
+		},
+		{
+			Pattern: "restore",
+			Fields: map[string]*framework.FieldSchema{
+				keyBackup: {
+					Type:        framework.TypeString,
+					Description: `Backup encrypted to this mount's wrapping key.`,
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRestoreWrite,
+				},
+			},
+			HelpSynopsis:    pathRestoreHelpSyn,
+			HelpDescription: pathRestoreHelpDesc,
+		},
+	}
+}
+
+func (b *backend) pathBackupWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawPublicKey, ok := d.GetOk(keyPublicKey)
+	if !ok {
+		return logical.ErrorResponse("missing public key"), logical.ErrInvalidRequest
+	}
+
+	publicKey, err := parseBackupPublicKey(rawPublicKey.(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	backup, err := b.createBackup(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	backupJson, err := json.Marshal(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedBackup, err := sealBackup(publicKey, backupJson)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info(fmt.Sprintf("Backup Created: mount=%s", req.MountPoint))
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyBackup: sealedBackup,
+		},
+	}, nil
+}
+
+func (b *backend) pathRestoreWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawBackup, ok := d.GetOk(keyBackup)
+	if !ok {
+		return logical.ErrorResponse("missing backup"), logical.ErrInvalidRequest
+	}
+
+	backupJson, err := b.unsealBackup(ctx, req.Storage, rawBackup.(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	var backup backupData
+	if err := json.Unmarshal(backupJson, &backup); err != nil {
+		return logical.ErrorResponse("error decoding backup: %v", err), logical.ErrInvalidRequest
+	}
+
+	if backup.Config == nil || backup.KeyIdNamespace == "" {
+		return logical.ErrorResponse("backup is incomplete"), logical.ErrInvalidRequest
+	}
+
+	for keyName, policy := range backup.Policies {
+		if err := validateBackupPolicy(policy); err != nil {
+			return logical.ErrorResponse("invalid key %s in backup: %v", keyName, err), logical.ErrInvalidRequest
+		}
+	}
+
+	empty, err := b.isEmptyMount(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if !empty {
+		return logical.ErrorResponse("backups can only be restored into a mount without roles, issuers or named keys"), logical.ErrInvalidRequest
+	}
+
+	if err := b.restoreBackup(ctx, req.Storage, &backup); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info(fmt.Sprintf("Backup Restored: mount=%s", req.MountPoint))
+
+	return nil, nil
+}
+
+// createBackup collects the mount's keys, configuration, roles, issuers and unexpired token revocations.
+func (b *backend) createBackup(ctx context.Context, stg logical.Storage) (*backupData, error) {
+	keyIdNamespace, err := b.getKeyIdNamespace(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := b.getConfig(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &backupData{
+		KeyIdNamespace: keyIdNamespace,
+		Config:         config,
+		Roles:          map[string]*Role{},
+		Issuers:        map[string]*Issuer{},
+		Keys:           map[string]*Key{},
+		Policies:       map[string]string{},
+		KeyHistory:     map[string]keyHistory{},
+		KeyRevocations: map[string]keyRevocations{},
+		KeyIdHistory:   map[string]keyIdHistory{},
+
+		TokenRevocations: []*tokenRevocation{},
+	}
+
+	roleNames, err := stg.List(ctx, keyStorageRolePath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, stg, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			backup.Roles[roleName] = role
+		}
+	}
+
+	issuerNames, err := stg.List(ctx, keyStorageIssuerPath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, issuerName := range issuerNames {
+		issuer, err := b.getIssuer(ctx, stg, issuerName)
+		if err != nil {
+			return nil, err
+		}
+		if issuer != nil {
+			backup.Issuers[issuerName] = issuer
+		}
+	}
+
+	keyNames, err := b.listKeyNames(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, keyName := range keyNames {
+
+		if keyName != mainKeyName {
+			key, err := b.getKey(ctx, stg, keyName)
+			if err != nil {
+				return nil, err
+			}
+			if key == nil {
+				continue
+			}
+			backup.Keys[keyName] = key
+		}
+
+		policy, _, err := b.lockManager.GetPolicy(ctx, keysutil.PolicyRequest{Storage: stg, Name: keyName}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			continue
+		}
+
+		backup.Policies[keyName], err = backupPolicy(ctx, stg, policy)
+		if err != nil {
+			return nil, err
+		}
+
+		backup.KeyHistory[keyName], err = b.getKeyHistory(ctx, stg, keyName)
+		if err != nil {
+			return nil, err
+		}
+
+		backup.KeyRevocations[keyName], err = b.getKeyRevocations(ctx, stg, keyName)
+		if err != nil {
+			return nil, err
+		}
+
+		backup.KeyIdHistory[keyName], err = b.getKeyIdHistory(ctx, stg, keyName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tokenRevocations, err := b.listTokenRevocations(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, revocation := range tokenRevocations {
+		if !revocation.isExpired(config, now) {
+			backup.TokenRevocations = append(backup.TokenRevocations, revocation)
+		}
+	}
+
+	return backup, nil
+}
+
+// backupPolicy encodes the policy and its archived versions in the format restored by the lock manager.
+// Unlike keysutil's own backup, this does not require the policy to be exportable.
+func backupPolicy(ctx context.Context, stg logical.Storage, policy *keysutil.Policy) (string, error) {
+	policy.Lock(false)
+	defer policy.Unlock()
+
+	archivedKeys, err := policy.LoadArchive(ctx, stg)
+	if err != nil {
+		return "", err
+	}
+
+	encodedPolicy, err := jsonutil.EncodeJSON(&keysutil.KeyData{
+		Policy:       policy,
+		ArchivedKeys: archivedKeys,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(encodedPolicy), nil
+}
+
+// isEmptyMount reports whether the mount has no roles, issuers or named keys. The main key is generated
+// on demand (e.g. by the periodic rotation check or a JWKS read) and, without roles or issuers, has not
+// signed any tokens, so it does not prevent a restore.
+func (b *backend) isEmptyMount(ctx context.Context, stg logical.Storage) (bool, error) {
+	roleNames, err := stg.List(ctx, keyStorageRolePath+"/")
+	if err != nil {
+		return false, err
+	}
+
+	issuerNames, err := stg.List(ctx, keyStorageIssuerPath+"/")
+	if err != nil {
+		return false, err
+	}
+
+	keyNames, err := stg.List(ctx, keyStorageKeyPath+"/")
+	if err != nil {
+		return false, err
+	}
+
+	return len(roleNames) == 0 && len(issuerNames) == 0 && len(keyNames) == 0, nil
+}
+
+// validateBackupPolicy checks the encoded policy can be restored by the lock manager.
+func validateBackupPolicy(policy string) error {
+	encodedPolicy, err := base64.StdEncoding.DecodeString(policy)
+	if err != nil {
+		return err
+	}
+
+	var keyData keysutil.KeyData
+	if err := jsonutil.DecodeJSON(encodedPolicy, &keyData); err != nil {
+		return err
+	}
+	if keyData.Policy == nil {
+		return fmt.Errorf("missing policy")
+	}
+
+	return nil
+}
+
+// restoreBackup writes the backup's keys, configuration, roles, issuers and token revocations into the mount.
+// When the restore fails, everything restored is removed, leaving the mount empty so the restore can be retried.
+func (b *backend) restoreBackup(ctx context.Context, stg logical.Storage, backup *backupData) (err error) {
+	defer func() {
+		if err != nil {
+			b.removeRestoredBackup(ctx, stg, backup)
+		}
+	}()
+
+	// Replace any unused main key generated before the restore
+	if err := b.deleteKeyVersions(ctx, stg, mainKeyName); err != nil {
+		return err
+	}
+
+	// Restored first, the policies are most likely to fail
+	for keyName, policy := range backup.Policies {
+		if err := b.lockManager.RestorePolicy(ctx, stg, keyName, policy, false); err != nil {
+			return fmt.Errorf("error restoring key %s: %w", keyName, err)
+		}
+	}
+
+	if err := b.setKeyIdNamespace(ctx, stg, backup.KeyIdNamespace); err != nil {
+		return err
+	}
+
+	// Saved directly, the restored policies already match the restored key format
+	b.cachedConfigLock.Lock()
+	err = b.saveConfigUnlocked(ctx, stg, backup.Config)
+	b.cachedConfigLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for roleName, role := range backup.Roles {
+		if err := b.setRole(ctx, stg, roleName, role); err != nil {
+			return err
+		}
+	}
+
+	for issuerName, issuer := range backup.Issuers {
+		if err := b.setIssuer(ctx, stg, issuerName, issuer); err != nil {
+			return err
+		}
+	}
+
+	for keyName, key := range backup.Keys {
+		if err := b.setKey(ctx, stg, keyName, key); err != nil {
+			return err
+		}
+	}
+
+	for keyName, history := range backup.KeyHistory {
+		if len(history) == 0 {
+			continue
+		}
+		if err := putStorageJSON(ctx, stg, path.Join(keyHistoryPath, keyName), history); err != nil {
+			return err
+		}
+	}
+
+	for keyName, revocations := range backup.KeyRevocations {
+		if len(revocations) == 0 {
+			continue
+		}
+		if err := putStorageJSON(ctx, stg, path.Join(keyRevocationsPath, keyName), revocations); err != nil {
+			return err
+		}
+	}
+
+	for keyName, history := range backup.KeyIdHistory {
+		if len(history) == 0 {
+			continue
+		}
+		if err := putStorageJSON(ctx, stg, path.Join(keyIdHistoryPath, keyName), history); err != nil {
+			return err
+		}
+	}
+
+	for _, revocation := range backup.TokenRevocations {
+		if err := b.recordTokenRevocation(ctx, stg, revocation); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeRestoredBackup removes the roles, issuers, keys and token revocations of a partially restored backup.
+// The configuration and key id namespace are left in place, they are replaced when the restore is retried.
+func (b *backend) removeRestoredBackup(ctx context.Context, stg logical.Storage, backup *backupData) {
+	logger := b.Logger()
+
+	removeEntry := func(entryPath string) {
+		if err := stg.Delete(ctx, entryPath); err != nil {
+			logger.Warn(fmt.Sprintf("Unable to remove restored entry: path=%s, error=%s", entryPath, err))
+		}
+	}
+
+	for roleName := range backup.Roles {
+		removeEntry(path.Join(keyStorageRolePath, roleName))
+	}
+
+	for issuerName := range backup.Issuers {
+		removeEntry(path.Join(keyStorageIssuerPath, issuerName))
+	}
+
+	keyNames := map[string]bool{mainKeyName: true}
+	for keyName := range backup.Keys {
+		keyNames[keyName] = true
+		removeEntry(path.Join(keyStorageKeyPath, keyName))
+	}
+	for keyName := range backup.Policies {
+		keyNames[keyName] = true
+	}
+	for keyName := range backup.KeyHistory {
+		keyNames[keyName] = true
+	}
+	for keyName := range backup.KeyRevocations {
+		keyNames[keyName] = true
+	}
+	for keyName := range backup.KeyIdHistory {
+		keyNames[keyName] = true
+	}
+
+	for keyName := range keyNames {
+		if err := b.deleteKeyVersions(ctx, stg, keyName); err != nil {
+			logger.Warn(fmt.Sprintf("Unable to remove restored key: key=%s, error=%s", keyName, err))
+		}
+	}
+
+	for _, revocation := range backup.TokenRevocations {
+		removeEntry(tokenRevocationPath(revocation.JTI))
+	}
+}
+
+func putStorageJSON(ctx context.Context, stg logical.Storage, key string, value interface{}) error {
+	entry, err := logical.StorageEntryJSON(key, value)
+	if err != nil {
+		return err
+	}
+
+	return stg.Put(ctx, entry)
+}
+
+// parseBackupPublicKey parses the PEM encoded RSA public key a backup is encrypted to.
+func parseBackupPublicKey(rawPublicKey string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(rawPublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("public key is not PEM encoded")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key: %w", err)
+	}
+
+	rsaPublicKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key must be an RSA key")
+	}
+	if rsaPublicKey.N.BitLen() < minBackupPublicKeyBits {
+		return nil, fmt.Errorf("public key must be at least %d bits", minBackupPublicKeyBits)
+	}
+
+	return rsaPublicKey, nil
+}
+
+// sealBackup encrypts the backup to the public key. An ephemeral AES-256 key, encrypted using RSA-OAEP
+// with SHA-256, is followed by the AES-GCM nonce and the backup encrypted with the ephemeral key.
+func sealBackup(publicKey *rsa.PublicKey, backup []byte) (string, error) {
+	ephemeralKey := make([]byte, 32)
+	if _, err := rand.Read(ephemeralKey); err != nil {
+		return "", err
+	}
+
+	ephemeralKeyEncrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, ephemeralKey, []byte{})
+	if err != nil {
+		return "", fmt.Errorf("error encrypting ephemeral key: %w", err)
+	}
+
+	aead, err := newBackupAEAD(ephemeralKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := append(ephemeralKeyEncrypted, nonce...)
+	sealed = aead.Seal(sealed, nonce, backup, nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unsealBackup decrypts a backup encrypted to the mount's wrapping key.
+func (b *backend) unsealBackup(ctx context.Context, stg logical.Storage, sealedBackup string) ([]byte, error) {
+	wrappingKey, err := b.getWrappingKey(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappingKeyEntry, ok := wrappingKey.Keys[strconv.Itoa(wrappingKey.LatestVersion)]
+	if !ok {
+		return nil, fmt.Errorf("missing wrapping key version")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(sealedBackup)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding backup: %w", err)
+	}
+
+	ephemeralKeySize := wrappingKeyEntry.RSAKey.Size()
+	if len(sealed) <= ephemeralKeySize {
+		return nil, fmt.Errorf("backup is too short")
+	}
+
+	ephemeralKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, wrappingKeyEntry.RSAKey, sealed[:ephemeralKeySize], []byte{})
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting ephemeral key: %w", err)
+	}
+
+	aead, err := newBackupAEAD(ephemeralKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed = sealed[ephemeralKeySize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("backup is too short")
+	}
+
+	backup, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting backup: %w", err)
+	}
+
+	return backup, nil
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+const pathBackupHelpSyn = `
+Create an encrypted backup of the mount's keys, configuration, roles, issuers and token revocations.
+`
+
+const pathBackupHelpDesc = `
+Creates a backup of every signing key (including retained verification versions), the configuration,
+all roles and issuers and the revocations of unexpired tokens, encrypted to the supplied RSA public key.
+Restored keys keep their key ids.
+
+To migrate a mount, encrypt the backup to the destination mount's 'wrapping_key' and restore it there
+directly. Backups encrypted to an operator's key must be decrypted and re-encrypted to the destination
+mount's wrapping key before restoring.
+
+The backup is base64 encoded; it starts with an ephemeral AES-256 key encrypted to the public key
+using RSA-OAEP with SHA-256, followed by the 12 byte nonce and the backup encrypted with the ephemeral
+key using AES-GCM.
+
+public_key: PEM encoded RSA public key, of at least 2048 bits, the backup is encrypted to.
+`
+
+const pathRestoreHelpSyn = `
+Restore an encrypted backup into the mount.
+`
+
+const pathRestoreHelpDesc = `
+Restores a backup, encrypted to this mount's 'wrapping_key', into a mount that has no roles, issuers or named keys.
+Restored keys keep their key ids, so tokens signed before the backup continue to verify and relying
+parties do not need to re-trust the JWKS. A failed restore leaves the mount empty, allowing it to be retried.
+
+backup: Backup encrypted to this mount's wrapping key.
+`
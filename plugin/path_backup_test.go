@@ -0,0 +1,425 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"testing"
+)
+
+func readWrappingKey(b *backend, storage *logical.Storage) (string, error) {
+	req := &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       "wrapping_key",
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return "", fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return resp.Data[keyPublicKey].(string), nil
+}
+
+func backupMount(b *backend, storage *logical.Storage, publicKey string) (string, error) {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "backup",
+		Storage:    *storage,
+		Data:       map[string]interface{}{keyPublicKey: publicKey},
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return "", fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return resp.Data[keyBackup].(string), nil
+}
+
+func restoreMount(b *backend, storage *logical.Storage, backup string) error {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "restore",
+		Storage:    *storage,
+		Data:       map[string]interface{}{keyBackup: backup},
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return nil
+}
+
+// failingStorage fails writes to entries with the prefix, simulating a storage failure part way through a restore.
+type failingStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *failingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if strings.HasPrefix(entry.Key, s.prefix) {
+		return fmt.Errorf("failed to write %s", entry.Key)
+	}
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestBackupRestore(t *testing.T) {
+	b, storage := getTestBackend(t)
+	restored, restoredStorage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keySignatureAlgorithm: "ES384"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeKey(b, storage, "edge", map[string]interface{}{keySignatureAlgorithm: "EdDSA"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRole(b, storage, "tester", "tester.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRoleWithKey(b, storage, "edger", "edger.example.com", "edge"); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, "tester", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	edgeToken, err := signToken(b, storage, "edger", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	revokedToken, err := signToken(b, storage, "tester", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if _, err := revokeToken(b, storage, map[string]interface{}{keyToken: revokedToken}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Encrypt the backup directly to the destination mount
+	wrappingKey, err := readWrappingKey(restored, restoredStorage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	backup, err := backupMount(b, storage, wrappingKey)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := restoreMount(restored, restoredStorage, backup); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Keys, including their ids, are restored
+	restoredJwkSet, err := FetchJWKS(restored, restoredStorage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(jwkSet, restoredJwkSet); diff != nil {
+		t.Error("restored jwks", diff)
+	}
+
+	for role, roleToken := range map[string]string{"tester": token, "edger": edgeToken} {
+		resp, err := verifyToken(restored, restoredStorage, role, roleToken)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+			t.Error("token should be valid", role, diff, resp.Data)
+		}
+	}
+
+	// Revoked tokens remain revoked
+	resp, err := verifyToken(restored, restoredStorage, "tester", revokedToken)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	expectVerifyFailure(t, resp, VerifyReasonRevokedToken)
+
+	// Restored keys continue to sign
+	restoredToken, err := signToken(restored, restoredStorage, "tester", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(tokenKeyID(t, token), tokenKeyID(t, restoredToken)); diff != nil {
+		t.Error("restored key id", diff)
+	}
+
+	config, err := restored.getConfig(context.Background(), *restoredStorage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal("ES384", string(config.SignatureAlgorithm)); diff != nil {
+		t.Error("restored signature algorithm", diff)
+	}
+
+	// Restoring requires an empty mount
+	if err := restoreMount(restored, restoredStorage, backup); err == nil {
+		t.Error("restoring into a mount with keys should have failed")
+	}
+}
+
+func TestRestoreAfterPeriodic(t *testing.T) {
+	b, storage := getTestBackend(t)
+	restored, restoredStorage := getTestBackend(t)
+
+	if err := writeRole(b, storage, "tester", "tester.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, "tester", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// The periodic rotation check and JWKS reads generate the destination's main key
+	if err := restored.periodic(context.Background(), &logical.Request{Storage: *restoredStorage, MountPoint: "test"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if _, err := FetchJWKS(restored, restoredStorage); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	wrappingKey, err := readWrappingKey(restored, restoredStorage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	backup, err := backupMount(b, storage, wrappingKey)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := restoreMount(restored, restoredStorage, backup); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	restoredJwkSet, err := FetchJWKS(restored, restoredStorage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(jwkSet, restoredJwkSet); diff != nil {
+		t.Error("restored jwks", diff)
+	}
+
+	resp, err := verifyToken(restored, restoredStorage, "tester", token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff, resp.Data)
+	}
+}
+
+func TestBackupRequiresRSAPublicKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	derKey, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: derKey}))
+
+	if _, err := backupMount(b, storage, publicKey); err == nil {
+		t.Error("backing up to an EC public key should have failed")
+	}
+
+	if _, err := backupMount(b, storage, "not a key"); err == nil {
+		t.Error("backing up to an invalid public key should have failed")
+	}
+}
+
+func TestRestoreWrongWrappingKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+	other, otherStorage := getTestBackend(t)
+	restored, restoredStorage := getTestBackend(t)
+
+	wrappingKey, err := readWrappingKey(other, otherStorage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	backup, err := backupMount(b, storage, wrappingKey)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := restoreMount(restored, restoredStorage, backup); err == nil {
+		t.Error("restoring a backup encrypted to another mount should have failed")
+	}
+}
+
+func TestRestoreAfterFailure(t *testing.T) {
+	for _, prefix := range []string{"policy/", keyStorageRolePath + "/", tokenRevocationsPath + "/"} {
+		t.Run(prefix, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			restored, restoredStorage := getTestBackend(t)
+
+			if err := writeRole(b, storage, "tester", "tester.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+				t.Fatalf("%v\n", err)
+			}
+
+			token, err := signToken(b, storage, "tester", map[string]interface{}{})
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+
+			revokedToken, err := signToken(b, storage, "tester", map[string]interface{}{})
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+			if _, err := revokeToken(b, storage, map[string]interface{}{keyToken: revokedToken}); err != nil {
+				t.Fatalf("%v\n", err)
+			}
+
+			wrappingKey, err := readWrappingKey(restored, restoredStorage)
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+
+			backup, err := backupMount(b, storage, wrappingKey)
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+
+			var failing logical.Storage = &failingStorage{Storage: *restoredStorage, prefix: prefix}
+			if err := restoreMount(restored, &failing, backup); err == nil {
+				t.Fatal("restoring with failing storage should have failed")
+			}
+
+			// The failed restore leaves the mount empty, allowing the restore to be retried
+			if err := restoreMount(restored, restoredStorage, backup); err != nil {
+				t.Fatalf("%v\n", err)
+			}
+
+			resp, err := verifyToken(restored, restoredStorage, "tester", token)
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+			if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+				t.Error("token should be valid", diff, resp.Data)
+			}
+
+			resp, err = verifyToken(restored, restoredStorage, "tester", revokedToken)
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+			expectVerifyFailure(t, resp, VerifyReasonRevokedToken)
+		})
+	}
+}
+
+func TestRestoreInvalidKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+	restored, restoredStorage := getTestBackend(t)
+
+	if err := writeRole(b, storage, "tester", "tester.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	wrappingKey, err := readWrappingKey(restored, restoredStorage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	publicKey, err := parseBackupPublicKey(wrappingKey)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	backup, err := b.createBackup(context.Background(), *storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	validBackupJson, err := json.Marshal(backup)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	backup.Policies[mainKeyName] = "bm90IGEga2V5"
+	invalidBackupJson, err := json.Marshal(backup)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	invalidBackup, err := sealBackup(publicKey, invalidBackupJson)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := restoreMount(restored, restoredStorage, invalidBackup); err == nil {
+		t.Fatal("restoring a backup with an invalid key should have failed")
+	}
+
+	roles, err := (*restoredStorage).List(context.Background(), keyStorageRolePath+"/")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(roles) != 0 {
+		t.Error("invalid backup should not restore roles", roles)
+	}
+
+	validBackup, err := sealBackup(publicKey, validBackupJson)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := restoreMount(restored, restoredStorage, validBackup); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+}
@@ -19,7 +19,9 @@ package jwtsecrets
 import (
 	"context"
 	"gopkg.in/square/go-jose.v2"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
@@ -30,15 +32,26 @@ const (
 	keySignatureAlgorithm  = "sig_alg"
 	keyRSAKeyBits          = "rsa_key_bits"
 	keyRotationDuration    = "key_ttl"
+	keyPrepublishDuration  = "key_prepublish"
 	keyTokenTTL            = "jwt_ttl"
+	keyMaxTokenTTL         = "max_jwt_ttl"
 	keySetIAT              = "set_iat"
 	keySetJTI              = "set_jti"
 	keySetNBF              = "set_nbf"
+	keyIssueLease          = "issue_lease"
 	keyAudiencePattern     = "audience_pattern"
 	keySubjectPattern      = "subject_pattern"
 	keyMaxAllowedAudiences = "max_audiences"
 	keyAllowedClaims       = "allowed_claims"
 	keyAllowedHeaders      = "allowed_headers"
+	keyVerifyLeeway        = "verify_leeway"
+	keyKidFormat           = "kid_format"
+	keyJtiFormat           = "jti_format"
+	keyBaseURL             = "base_url"
+	keyJwksMaxAge          = "jwks_max_age"
+
+	keyVerificationKeyRetention = "verification_key_retention"
+	keyMinVerificationKeys      = "min_verification_keys"
 )
 
 func pathConfig(b *backend) *framework.Path {
@@ -57,10 +70,18 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: `Duration a specific key will be used to sign new tokens.`,
 			},
+			keyPrepublishDuration: {
+				Type:        framework.TypeString,
+				Description: `Duration a new key is published before it is used to sign new tokens.`,
+			},
 			keyTokenTTL: {
 				Type:        framework.TypeString,
 				Description: `Duration a token is valid for (mapped to the 'exp' claim).`,
 			},
+			keyMaxTokenTTL: {
+				Type:        framework.TypeString,
+				Description: `Maximum duration a token can be valid for, when requested by roles or sign requests. Defaults to the token TTL.`,
+			},
 			keySetIAT: {
 				Type:        framework.TypeBool,
 				Description: `Whether or not the backend should generate and set the 'iat' claim.`,
@@ -73,6 +94,10 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeBool,
 				Description: `Whether or not the backend should generate and set the 'nbf' claim.`,
 			},
+			keyIssueLease: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not the backend should create a lease for each signed token.`,
+			},
 			keyIssuer: {
 				Type:        framework.TypeString,
 				Description: `Value to set as the 'iss' claim. Claim is omitted if empty.`,
@@ -98,6 +123,42 @@ Note: 'aud' and 'sub' should be in this list if you would like to set them.`,
 				Type:        framework.TypeStringSlice,
 				Description: `Headers which are able to be set in addition to ones generated by the backend.`,
 			},
+			keyClaimConstraints: {
+				Type: framework.TypeMap,
+				Description: `Constraints on the values of claims provided during sign requests, by claim name. Each constraint
+may declare a 'type', 'pattern', 'enum', 'min', 'max' and 'max_items'.`,
+			},
+			keyVerifyLeeway: {
+				Type:        framework.TypeString,
+				Description: `Clock skew tolerated when validating the 'exp', 'nbf' and 'iat' claims of verified tokens.`,
+			},
+			keyVerificationKeyRetention: {
+				Type: framework.TypeString,
+				Description: `Duration a key version remains available for verification after it stops signing new tokens.
+Versions are always retained until all tokens signed with them have expired.`,
+			},
+			keyMinVerificationKeys: {
+				Type:        framework.TypeInt,
+				Description: `Minimum number of key versions retained for verification, regardless of their age.`,
+			},
+			keyJtiFormat: {
+				Type:        framework.TypeString,
+				Description: `Format of generated 'jti' claims: 'friendly', 'uuid', 'uuidv7', 'ulid' or 'random'.`,
+			},
+			keyKidFormat: {
+				Type: framework.TypeString,
+				Description: `Format of the 'kid' of each key version: 'legacy', 'thumbprint' (RFC 7638 SHA-256 JWK thumbprint)
+or a template containing '{{version}}' and optionally '{{mount}}' and '{{key}}'. Only applies to key versions created
+after it is changed.`,
+			},
+			keyBaseURL: {
+				Type:        framework.TypeString,
+				Description: `Externally reachable URL of the mount (e.g. https://vault.example.com/v1/jwt), used in discovery documents.`,
+			},
+			keyJwksMaxAge: {
+				Type:        framework.TypeString,
+				Description: `Maximum duration JWKS responses can be cached for, until the next scheduled key rotation.`,
+			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -166,6 +227,18 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, d *
 		config.KeyRotationPeriod = duration
 	}
 
+	if newPrepublishPeriod, ok := d.GetOk(keyPrepublishDuration); ok {
+		duration, err := time.ParseDuration(newPrepublishPeriod.(string))
+		if err != nil {
+			return nil, err
+		}
+		config.KeyPrepublishPeriod = duration
+	}
+
+	if config.KeyPrepublishPeriod < 0 || config.KeyPrepublishPeriod >= config.KeyRotationPeriod {
+		return logical.ErrorResponse("'%s' must be less than '%s'", keyPrepublishDuration, keyRotationDuration), logical.ErrInvalidRequest
+	}
+
 	if newTTL, ok := d.GetOk(keyTokenTTL); ok {
 		duration, err := time.ParseDuration(newTTL.(string))
 		if err != nil {
@@ -174,6 +247,17 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, d *
 		config.TokenTTL = duration
 	}
 
+	if newMaxTTL, ok := d.GetOk(keyMaxTokenTTL); ok {
+		duration, err := time.ParseDuration(newMaxTTL.(string))
+		if err != nil {
+			return nil, err
+		}
+		if duration < 0 {
+			return logical.ErrorResponse("'%s' cannot be negative", keyMaxTokenTTL), logical.ErrInvalidRequest
+		}
+		config.MaxTokenTTL = duration
+	}
+
 	if newSetIat, ok := d.GetOk(keySetIAT); ok {
 		config.SetIAT = newSetIat.(bool)
 	}
@@ -186,6 +270,11 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, d *
 		config.SetNBF = newSetNBF.(bool)
 	}
 
+	if newIssueLease, ok := d.GetOk(keyIssueLease); ok {
+		issueLease := newIssueLease.(bool)
+		config.IssueLease = &issueLease
+	}
+
 	if newAudiencePattern, ok := d.GetOk(keyAudiencePattern); ok {
 		config.AudiencePattern = newAudiencePattern.(string)
 		_, err := regexp.Compile(config.AudiencePattern)
@@ -230,9 +319,107 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, d *
 		config.AllowedHeaders = newAllowedHeaders.([]string)
 	}
 
+	if newClaimConstraints, ok := d.GetOk(keyClaimConstraints); ok {
+		claimConstraints, err := parseClaimConstraints(newClaimConstraints.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		config.ClaimConstraints = claimConstraints
+	}
+
+	if newVerifyLeeway, ok := d.GetOk(keyVerifyLeeway); ok {
+		duration, err := time.ParseDuration(newVerifyLeeway.(string))
+		if err != nil {
+			return nil, err
+		}
+		if duration < 0 {
+			return logical.ErrorResponse("'%s' cannot be negative", keyVerifyLeeway), logical.ErrInvalidRequest
+		}
+		config.VerifyLeeway = duration
+	}
+
+	if newRetention, ok := d.GetOk(keyVerificationKeyRetention); ok {
+		duration, err := time.ParseDuration(newRetention.(string))
+		if err != nil {
+			return nil, err
+		}
+		if duration < 0 {
+			return logical.ErrorResponse("'%s' cannot be negative", keyVerificationKeyRetention), logical.ErrInvalidRequest
+		}
+		config.VerificationKeyRetention = duration
+	}
+
+	if newMinVerificationKeys, ok := d.GetOk(keyMinVerificationKeys); ok {
+		if newMinVerificationKeys.(int) < 1 {
+			return logical.ErrorResponse("'%s' must be at least 1", keyMinVerificationKeys), logical.ErrInvalidRequest
+		}
+		config.MinVerificationKeys = newMinVerificationKeys.(int)
+	}
+
+	if newJtiFormat, ok := d.GetOk(keyJtiFormat); ok {
+		jtiFormat := newJtiFormat.(string)
+		if !stringInSlice(jtiFormat, AllowedJtiFormats) {
+			return logical.ErrorResponse("unknown '%s' %s, must be one of %s", keyJtiFormat, jtiFormat, strings.Join(AllowedJtiFormats, ", ")), logical.ErrInvalidRequest
+		}
+		config.JtiFormat = jtiFormat
+	}
+
+	previousKidFormat := config.KidFormat
+	if newKidFormat, ok := d.GetOk(keyKidFormat); ok {
+		kidFormat := newKidFormat.(string)
+		if err := validateKeyIdFormat(kidFormat); err != nil {
+			return logical.ErrorResponse("invalid '%s': %s", keyKidFormat, err), logical.ErrInvalidRequest
+		}
+		if !keyIdFormatDistinguishesKeys(kidFormat) {
+			keyNames, err := b.listKeyNames(ctx, req.Storage)
+			if err != nil {
+				return nil, err
+			}
+			if len(keyNames) > 1 {
+				return logical.ErrorResponse("'%s' must contain %s when keys other than '%s' exist", keyKidFormat, keyIdTemplateKey, mainKeyName), logical.ErrInvalidRequest
+			}
+		}
+		config.KidFormat = kidFormat
+	}
+
+	if newBaseURL, ok := d.GetOk(keyBaseURL); ok {
+		baseURL := strings.TrimSuffix(newBaseURL.(string), "/")
+		if baseURL != "" {
+			parsedURL, err := url.Parse(baseURL)
+			if err != nil || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || parsedURL.Host == "" || parsedURL.RawQuery != "" || parsedURL.Fragment != "" {
+				return logical.ErrorResponse("'%s' must be an absolute http(s) URL without query or fragment", keyBaseURL), logical.ErrInvalidRequest
+			}
+		}
+		config.BaseURL = baseURL
+	}
+
+	if newJwksMaxAge, ok := d.GetOk(keyJwksMaxAge); ok {
+		duration, err := time.ParseDuration(newJwksMaxAge.(string))
+		if err != nil {
+			return nil, err
+		}
+		if duration < 0 {
+			return logical.ErrorResponse("'%s' cannot be negative", keyJwksMaxAge), logical.ErrInvalidRequest
+		}
+		config.JwksMaxAge = &duration
+	}
+
 	if config.TokenTTL > b.System().MaxLeaseTTL() {
 		return logical.ErrorResponse("'%s' is greater that the max lease ttl", keyTokenTTL), logical.ErrInvalidRequest
 	}
+	if config.MaxTokenTTL > b.System().MaxLeaseTTL() {
+		return logical.ErrorResponse("'%s' is greater that the max lease ttl", keyMaxTokenTTL), logical.ErrInvalidRequest
+	}
+	if config.MaxTokenTTL != 0 && config.MaxTokenTTL < config.TokenTTL {
+		return logical.ErrorResponse("'%s' cannot be less than '%s'", keyMaxTokenTTL, keyTokenTTL), logical.ErrInvalidRequest
+	}
+
+	if config.KidFormat != previousKidFormat {
+		// Existing key versions keep their key ids, tokens they signed must continue to verify
+		if err := b.recordKeyIdFormat(ctx, req.Storage, previousKidFormat); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := b.saveConfig(ctx, req.Storage, config, req.MountPoint); err != nil {
 		return nil, err
@@ -265,15 +452,27 @@ func configResponse(config *Config) (*logical.Response, error) {
 			keySignatureAlgorithm:  config.SignatureAlgorithm,
 			keyRSAKeyBits:          config.RSAKeyBits,
 			keyRotationDuration:    config.KeyRotationPeriod.String(),
+			keyPrepublishDuration:  config.KeyPrepublishPeriod.String(),
 			keyTokenTTL:            config.TokenTTL.String(),
+			keyMaxTokenTTL:         config.MaxTokenTTL.String(),
 			keySetIAT:              config.SetIAT,
 			keySetJTI:              config.SetJTI,
 			keySetNBF:              config.SetNBF,
+			keyIssueLease:          config.issueLease(),
 			keyAudiencePattern:     config.AudiencePattern,
 			keySubjectPattern:      config.SubjectPattern,
 			keyMaxAllowedAudiences: config.MaxAudiences,
 			keyAllowedClaims:       config.AllowedClaims,
 			keyAllowedHeaders:      config.AllowedHeaders,
+			keyClaimConstraints:    config.ClaimConstraints,
+			keyVerifyLeeway:        config.VerifyLeeway.String(),
+			keyKidFormat:           config.KidFormat,
+			keyJtiFormat:           config.JtiFormat,
+			keyBaseURL:             config.BaseURL,
+			keyJwksMaxAge:          config.jwksMaxAge().String(),
+
+			keyVerificationKeyRetention: config.VerificationKeyRetention.String(),
+			keyMinVerificationKeys:      config.MinVerificationKeys,
 		},
 	}, nil
 }
@@ -307,6 +506,7 @@ sig_alg:		  Signature algorithm used to sign new tokens.
 rsa_key_bits:	  Size of generate RSA keys, when using RSA signature algorithms.
 key_ttl:          Duration before a key stops signing new tokens and a new one is generated.
 		          After this period the public key will still be available to verify JWTs.
+key_prepublish:   Duration a new key is published before it is used to sign new tokens.
 jwt_ttl:          Duration before a token expires.
 set_iat:          Whether or not the backend should generate and set the 'iat' claim.
 set_jti:          Whether or not the backend should generate and set the 'jti' claim.
@@ -317,4 +517,18 @@ subject_pattern:  Regular expression which must match incoming 'sub' claims.
 max_audiences:    Maximum number of allowed audiences, or -1 for no limit.
 allowed_claims:   Claims which are able to be set in addition to ones generated by the backend.
                   Note: 'aud' and 'sub' should be in this list if you would like to set them.
+claim_constraints:
+                  Constraints on the values of claims provided during sign requests, by claim name. Each constraint
+                  may declare a 'type', 'pattern', 'enum', 'min', 'max' and 'max_items'.
+verify_leeway:    Clock skew tolerated when validating the 'exp', 'nbf' and 'iat' claims of verified tokens.
+verification_key_retention:
+                  Duration a key version remains available for verification after it stops signing new tokens.
+                  Versions are always retained until all tokens signed with them have expired.
+min_verification_keys:
+                  Minimum number of key versions retained for verification, regardless of their age.
+kid_format:       Format of the 'kid' of each key version: 'legacy', 'thumbprint' (RFC 7638 SHA-256 JWK thumbprint)
+                  or a template containing '{{version}}' and optionally '{{mount}}' and '{{key}}'.
+                  Existing key versions keep their key ids when it is changed.
+base_url:         Externally reachable URL of the mount (e.g. https://vault.example.com/v1/jwt), used in discovery documents.
+jwks_max_age:     Maximum duration JWKS responses can be cached for, until the next scheduled key rotation.
 `
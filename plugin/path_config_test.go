@@ -18,6 +18,7 @@ package jwtsecrets
 
 import (
 	"context"
+	"encoding/json"
 	"gopkg.in/square/go-jose.v2"
 	"testing"
 
@@ -79,6 +80,66 @@ func TestDefaultConfig(t *testing.T) {
 	}
 }
 
+func TestUpgradeConfig(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	// Store a configuration saved before the newer options were available
+	rawConfig, err := json.Marshal(DefaultConfig(b.System()))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var savedConfig map[string]interface{}
+	if err := json.Unmarshal(rawConfig, &savedConfig); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	for _, field := range []string{"KidFormat", "JtiFormat", "IssueLease", "JwksMaxAge"} {
+		delete(savedConfig, field)
+	}
+
+	entry, err := logical.StorageEntryJSON(configPath, savedConfig)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := (*storage).Put(context.Background(), entry); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	req := &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       "config",
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal(KeyIdFormatLegacy, resp.Data[keyKidFormat]); diff != nil {
+		t.Error("kid format", diff)
+	}
+	if diff := deep.Equal(JtiFormatFriendly, resp.Data[keyJtiFormat]); diff != nil {
+		t.Error("jti format", diff)
+	}
+	if diff := deep.Equal(DefaultIssueLease, resp.Data[keyIssueLease]); diff != nil {
+		t.Error("issue lease", diff)
+	}
+	if diff := deep.Equal(DefaultJwksMaxAge, resp.Data[keyJwksMaxAge]); diff != nil {
+		t.Error("jwks max age", diff)
+	}
+
+	// An explicit zero is kept
+	resp, err = writeConfig(b, storage, map[string]interface{}{keyJwksMaxAge: "0s"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal("0s", resp.Data[keyJwksMaxAge]); diff != nil {
+		t.Error("jwks max age", diff)
+	}
+}
+
 func TestWriteConfig(t *testing.T) {
 	b, storage := getTestBackend(t)
 
@@ -212,3 +273,60 @@ func TestWriteInvalidConfig(t *testing.T) {
 		t.Errorf("Should have errored but got response: %#v", resp)
 	}
 }
+
+func TestWriteInvalidKidFormat(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	for _, kidFormat := range []string{"", "sha1", "{{mount}}-{{key}}", "{{key}}-{{version}}-{{role}}"} {
+		if resp, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: kidFormat}); err == nil {
+			t.Errorf("kid format %q should have errored but got response: %#v", kidFormat, resp)
+		}
+	}
+
+	// Templates without the key name are only allowed while the main key is the only key
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: "{{mount}}-{{version}}"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	if err := writeKey(b, storage, "other", map[string]interface{}{}); err == nil {
+		t.Error("creating a key should have failed")
+	}
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: KeyIdFormatLegacy}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	if err := writeKey(b, storage, "other", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: "{{mount}}-{{version}}"}); err == nil {
+		t.Errorf("Should have errored but got response: %#v", resp)
+	}
+}
+
+func TestWriteInvalidConfigKeepsKidFormat(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeRole(b, storage, "tester", "tester.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := signToken(b, storage, "tester", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	data := map[string]interface{}{keyKidFormat: KeyIdFormatThumbprint, keyTokenTTL: "1000h"}
+	if resp, err := writeConfig(b, storage, data); err == nil {
+		t.Fatalf("Should have errored but got response: %#v", resp)
+	}
+
+	// The rejected kid format is not recorded in the key id history
+	history, err := b.getKeyIdHistory(context.Background(), *storage, mainKeyName)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(history) != 0 {
+		t.Error("rejected config should not record key id history", history)
+	}
+}
@@ -0,0 +1,125 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"regexp"
+	"sort"
+)
+
+const discoveryPath = ".well-known/openid-configuration"
+
+// discoveryDocument is the subset of OpenID Connect provider metadata relevant to verifying tokens.
+type discoveryDocument struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+func pathDiscovery(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: regexp.QuoteMeta(discoveryPath),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathDiscoveryRead,
+			},
+		},
+
+		HelpSynopsis:    pathDiscoveryHelpSyn,
+		HelpDescription: pathDiscoveryHelpDesc,
+	}
+}
+
+func (b *backend) pathDiscoveryRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BaseURL == "" {
+		return logical.ErrorResponse("discovery requires '%s' to be configured", keyBaseURL), logical.ErrInvalidRequest
+	}
+
+	jwkSet, err := b.getPublicKeys(ctx, req.Storage, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return discoveryResponse(config, config.BaseURL, config.BaseURL+"/jwks", jwkSet)
+}
+
+// discoveryResponse builds the discovery document of an issuer whose keys are published at jwksURI.
+func discoveryResponse(config *Config, issuer string, jwksURI string, jwkSet *jose.JSONWebKeySet) (*logical.Response, error) {
+
+	algorithms := map[string]bool{}
+	for _, key := range jwkSet.Keys {
+		algorithms[key.Algorithm] = true
+	}
+
+	claims := map[string]bool{}
+	for _, claim := range append(append([]string{}, ReservedClaims...), config.AllowedClaims...) {
+		claims[claim] = true
+	}
+
+	document, err := json.Marshal(discoveryDocument{
+		Issuer:                           issuer,
+		JWKSURI:                          jwksURI,
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: sortedKeys(algorithms),
+		ClaimsSupported:                  sortedKeys(claims),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  200,
+			logical.HTTPContentType: "application/json",
+			logical.HTTPRawBody:     document,
+		},
+	}, nil
+}
+
+// sortedKeys returns the keys of the set in sorted order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+const pathDiscoveryHelpSyn = `
+Get the OpenID Connect discovery document.
+`
+
+const pathDiscoveryHelpDesc = `
+Get the OpenID Connect discovery document of the mount, which advertises the JWKS of the mount and the
+signing algorithms and claims of its tokens. The issuer and JWKS URI are derived from the configured
+'base_url'; roles should use the same URL as their issuer for tokens to be accepted by OpenID Connect verifiers.
+`
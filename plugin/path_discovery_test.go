@@ -0,0 +1,102 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+)
+
+func fetchDiscovery(b *backend, storage *logical.Storage, path string) (*discoveryDocument, error) {
+	req := &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       path,
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, resp.Error()
+	}
+
+	rawBody, ok := resp.Data[logical.HTTPRawBody].([]byte)
+	if !ok {
+		return nil, errors.New("no raw body returned")
+	}
+
+	document := &discoveryDocument{}
+	if err := json.Unmarshal(rawBody, document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+func TestDiscovery(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := fetchDiscovery(b, storage, discoveryPath); err == nil {
+		t.Error("discovery without a base url should have failed")
+	}
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyBaseURL:       "https://vault.example.com/v1/jwt/",
+		keyAllowedClaims: []string{"sub", "aud", "email"},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeKey(b, storage, "other", map[string]interface{}{keySignatureAlgorithm: "RS256"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	document, err := fetchDiscovery(b, storage, discoveryPath)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expected := &discoveryDocument{
+		Issuer:                           "https://vault.example.com/v1/jwt",
+		JWKSURI:                          "https://vault.example.com/v1/jwt/jwks",
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{"ES256", "RS256"},
+		ClaimsSupported:                  []string{"aud", "email", "exp", "iat", "iss", "jti", "nbf", "sub"},
+	}
+
+	if diff := deep.Equal(expected, document); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestWriteInvalidBaseURL(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	for _, baseURL := range []string{"vault.example.com/v1/jwt", "ftp://vault.example.com", "https://vault.example.com/v1/jwt?x=1"} {
+		if resp, err := writeConfig(b, storage, map[string]interface{}{keyBaseURL: baseURL}); err == nil {
+			t.Errorf("base url %q should have errored but got response: %#v", baseURL, resp)
+		}
+	}
+}
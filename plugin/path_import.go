@@ -0,0 +1,247 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"github.com/google/tink/go/kwp/subtle"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	keyCiphertext    = "ciphertext"
+	keyHashFunction  = "hash_function"
+	keyAllowRotation = "allow_rotation"
+)
+
+const defaultHashFunction = "SHA256"
+
+func pathImport(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex(keyKeyName) + "/import",
+		Fields: map[string]*framework.FieldSchema{
+			keyKeyName: {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the key",
+				Required:    true,
+			},
+			keyCiphertext: {
+				Type: framework.TypeString,
+				Description: `The base64-encoded ciphertext of the wrapped keys. The first 512 bytes are an ephemeral
+AES-256 key encrypted with the wrapping key using RSA-OAEP; the remaining bytes are the PKCS#8
+DER encoded private key wrapped with the ephemeral key using AES-KWP.`,
+				Required: true,
+			},
+			keyHashFunction: {
+				Type:        framework.TypeString,
+				Description: `Hash function used for RSA-OAEP; one of SHA1, SHA224, SHA256, SHA384 or SHA512.`,
+				Default:     defaultHashFunction,
+			},
+			keyAllowRotation: {
+				Type:        framework.TypeBool,
+				Description: `Whether the key may be rotated, allowing a generated version to replace the imported version.`,
+				Default:     false,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathImportWrite,
+			},
+		},
+		HelpSynopsis:    pathImportHelpSyn,
+		HelpDescription: pathImportHelpDesc,
+	}
+}
+
+func (b *backend) pathImportWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keyName := d.Get(keyKeyName).(string)
+	allowRotation := d.Get(keyAllowRotation).(bool)
+
+	hashFunction, err := importHashFunction(d.Get(keyHashFunction).(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	rawCiphertext, ok := d.GetOk(keyCiphertext)
+	if !ok {
+		return logical.ErrorResponse("missing ciphertext"), logical.ErrInvalidRequest
+	}
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := b.getSigningKey(ctx, req.Storage, config, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return logical.ErrorResponse("unknown key %s", keyName), logical.ErrInvalidRequest
+	}
+
+	keyType, err := keyTypeForAlgorithm(key.SignatureAlgorithm, key.RSAKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	importedKey, err := b.unwrapImportedKey(ctx, req.Storage, rawCiphertext.(string), hashFunction)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	policy, _, err := b.lockManager.GetPolicy(ctx, keysutil.PolicyRequest{Storage: req.Storage, Name: keyName}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy == nil {
+		polReq := keysutil.PolicyRequest{
+			Storage:                  req.Storage,
+			Name:                     keyName,
+			KeyType:                  keyType,
+			AllowImportedKeyRotation: allowRotation,
+			IsPrivateKey:             true,
+		}
+
+		if err := b.lockManager.ImportPolicy(ctx, polReq, importedKey, rand.Reader); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+	} else {
+		if err := b.importPolicyVersion(ctx, req.Storage, policy, importedKey, allowRotation); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+	}
+
+	b.Logger().Info(fmt.Sprintf("Key Imported: mount=%s, key=%s", req.MountPoint, keyName))
+
+	return nil, nil
+}
+
+// importPolicyVersion adds the imported key as the latest version of an existing policy.
+func (b *backend) importPolicyVersion(ctx context.Context, stg logical.Storage, policy *keysutil.Policy, importedKey []byte, allowRotation bool) error {
+	policy.Lock(true)
+	defer policy.Unlock()
+
+	previousImported := policy.Imported
+	previousAllowImportedKeyRotation := policy.AllowImportedKeyRotation
+
+	policy.Imported = true
+	policy.AllowImportedKeyRotation = allowRotation
+
+	if err := policy.ImportPublicOrPrivate(ctx, stg, importedKey, true, rand.Reader); err != nil {
+		policy.Imported = previousImported
+		policy.AllowImportedKeyRotation = previousAllowImportedKeyRotation
+		return fmt.Errorf("error importing key: %w", err)
+	}
+
+	b.lockManager.InvalidatePolicy(policy.Name)
+
+	return nil
+}
+
+// unwrapImportedKey decrypts a key wrapped using the wrapping key, returning the PKCS#8 DER encoded private key.
+func (b *backend) unwrapImportedKey(ctx context.Context, stg logical.Storage, ciphertext string, hashFunction hash.Hash) ([]byte, error) {
+	wrappingKey, err := b.getWrappingKey(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappingKeyEntry, ok := wrappingKey.Keys[strconv.Itoa(wrappingKey.LatestVersion)]
+	if !ok {
+		return nil, fmt.Errorf("missing wrapping key version")
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding ciphertext: %w", err)
+	}
+
+	ephemeralKeySize := wrappingKeyEntry.RSAKey.Size()
+	if len(wrapped) <= ephemeralKeySize {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+
+	ephemeralKey, err := rsa.DecryptOAEP(hashFunction, rand.Reader, wrappingKeyEntry.RSAKey, wrapped[:ephemeralKeySize], []byte{})
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting ephemeral key: %w", err)
+	}
+
+	kwp, err := subtle.NewKWP(ephemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating key unwrapper: %w", err)
+	}
+
+	importedKey, err := kwp.Unwrap(wrapped[ephemeralKeySize:])
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping key: %w", err)
+	}
+
+	return importedKey, nil
+}
+
+// importHashFunction returns the RSA-OAEP hash function named by the request.
+func importHashFunction(name string) (hash.Hash, error) {
+	switch strings.ToUpper(name) {
+	case "SHA1":
+		return sha1.New(), nil
+	case "SHA224":
+		return sha256.New224(), nil
+	case "SHA256":
+		return sha256.New(), nil
+	case "SHA384":
+		return sha512.New384(), nil
+	case "SHA512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash function %s", name)
+	}
+}
+
+const pathImportHelpSyn = `
+Import an existing private key as the latest version of a signing key.
+`
+
+const pathImportHelpDesc = `
+Imports an existing private key as the latest version of the key. The imported version is published
+immediately and signs new tokens once it has been published for the key's 'key_prepublish' period, or
+immediately when no pre-publication period is configured; until then the previous version continues
+to sign. The private key must match the key's signature algorithm (and RSA key size).
+
+The private key is never sent in plaintext. Generate an ephemeral AES-256 key, wrap the PKCS#8 DER
+encoded private key with it using AES-KWP (RFC 5649), and encrypt the ephemeral key with the public
+key from 'wrapping_key' using RSA-OAEP. The base64 encoded concatenation of the encrypted ephemeral
+key and the wrapped private key is the 'ciphertext'. This is the same format used by Vault's Transit
+secrets engine.
+
+ciphertext:     Base64 encoded wrapped keys.
+hash_function:  Hash function used for RSA-OAEP; defaults to SHA256.
+allow_rotation: Whether the key may be rotated. By default, imported keys are not rotated and remain
+                the signing key until another key is imported.
+`
@@ -0,0 +1,304 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/google/tink/go/kwp/subtle"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"testing"
+)
+
+// wrapKeyForImport wraps a private key using the backend's wrapping key, as an operator would.
+func wrapKeyForImport(b *backend, storage *logical.Storage, privateKey crypto.PrivateKey) (string, error) {
+	req := &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       "wrapping_key",
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return "", fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	block, _ := pem.Decode([]byte(resp.Data[keyPublicKey].(string)))
+	if block == nil {
+		return "", fmt.Errorf("wrapping key is not PEM encoded")
+	}
+
+	wrappingKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+
+	targetKey, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	ephemeralKey := make([]byte, 32)
+	if _, err := rand.Read(ephemeralKey); err != nil {
+		return "", err
+	}
+
+	ephemeralKeyWrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, wrappingKey.(*rsa.PublicKey), ephemeralKey, []byte{})
+	if err != nil {
+		return "", err
+	}
+
+	kwp, err := subtle.NewKWP(ephemeralKey)
+	if err != nil {
+		return "", err
+	}
+
+	targetKeyWrapped, err := kwp.Wrap(targetKey)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(append(ephemeralKeyWrapped, targetKeyWrapped...)), nil
+}
+
+func importKey(b *backend, storage *logical.Storage, name string, data map[string]interface{}) error {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "keys/" + name + "/import",
+		Storage:    *storage,
+		Data:       data,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return nil
+}
+
+func TestImportKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	ciphertext, err := wrapKeyForImport(b, storage, privateKey)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeKey(b, storage, "partner", map[string]interface{}{keySignatureAlgorithm: "ES256"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := importKey(b, storage, "partner", map[string]interface{}{keyCiphertext: ciphertext}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleWithKey(b, storage, "tester", "tester.example.com", "partner"); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, "tester", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Tokens are signed with the imported key
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	claims := jwt.Claims{}
+	if err := parsed.Claims(privateKey.Public(), &claims); err != nil {
+		t.Fatalf("token should verify with the imported key: %v\n", err)
+	}
+
+	resp, err := readKey(b, storage, "partner")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(true, resp.Data[keyImported]); diff != nil {
+		t.Error("imported", diff)
+	}
+	if diff := deep.Equal(false, resp.Data[keyAllowRotation]); diff != nil {
+		t.Error("allow rotation", diff)
+	}
+
+	// Imported keys remain the signer
+	if err := rotateKey(b, storage, "partner"); err == nil {
+		t.Fatal("rotating an imported key should have failed")
+	}
+}
+
+func TestImportKeyVersion(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	ciphertext, err := wrapKeyForImport(b, storage, privateKey)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := importKey(b, storage, mainKeyName, map[string]interface{}{keyCiphertext: ciphertext, keyAllowRotation: true}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Both the generated and imported versions are published
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 2); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff, resp.Data)
+	}
+
+	token, err = signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(jwkSet.Keys[1].KeyID, tokenKeyID(t, token)); diff != nil {
+		t.Error("token should be signed with the imported key", diff)
+	}
+
+	if err := rotateKey(b, storage, mainKeyName); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+}
+
+func TestImportKeyVersionPrepublish(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration:   "1h",
+		keyPrepublishDuration: "30m",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	previousKid := tokenKeyID(t, token)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	ciphertext, err := wrapKeyForImport(b, storage, privateKey)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := importKey(b, storage, mainKeyName, map[string]interface{}{keyCiphertext: ciphertext}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// The imported version is published immediately
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 2); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+
+	// But the previous version signs until the imported version has been published for the prepublish period
+	token, err = signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(previousKid, tokenKeyID(t, token)); diff != nil {
+		t.Error("token should be signed with the previous key", diff)
+	}
+	if tokenKeyID(t, token) == jwkSet.Keys[1].KeyID {
+		t.Error("token should not be signed with the imported key")
+	}
+}
+
+func TestImportMismatchedKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	ciphertext, err := wrapKeyForImport(b, storage, privateKey)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := importKey(b, storage, mainKeyName, map[string]interface{}{keyCiphertext: ciphertext}); err == nil {
+		t.Fatal("importing an RSA key into an ES256 key should have failed")
+	}
+
+	if err := importKey(b, storage, mainKeyName, map[string]interface{}{keyCiphertext: "bm90IHdyYXBwZWQ="}); err == nil {
+		t.Fatal("importing an invalid ciphertext should have failed")
+	}
+}
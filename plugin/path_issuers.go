@@ -0,0 +1,406 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"net/url"
+	"path"
+	"regexp"
+)
+
+const (
+	keyStorageIssuerPath = "issuer"
+	keyIssuerName        = "name"
+	keyIssuerRef         = "issuer_ref"
+
+	issuersPath = "issuers"
+)
+
+// Issuer defines a named token issuer, which publishes the keys used to sign its tokens separately from
+// the keys of other issuers.
+type Issuer struct {
+
+	// Issuer defines the 'iss' claim of tokens issued by the issuer. If empty, it is derived from the configured
+	// base URL, matching the issuer's discovery document.
+	Issuer string `json:"issuer"`
+
+	// Key defines the name of the key used to sign tokens of the issuer. If empty, the main key is used.
+	Key string `json:"key"`
+}
+
+// signingKeyName returns the name of the key used to sign tokens of the issuer.
+func (i *Issuer) signingKeyName() string {
+	if i.Key == "" {
+		return mainKeyName
+	}
+	return i.Key
+}
+
+// issuerURL returns the 'iss' claim of tokens issued by the named issuer, or an empty string when it has
+// no explicit issuer and no base URL is configured.
+func (i *Issuer) issuerURL(config *Config, name string) string {
+	if i.Issuer != "" {
+		return i.Issuer
+	}
+	if config.BaseURL == "" {
+		return ""
+	}
+	return issuerBaseURL(config, name)
+}
+
+// issuerBaseURL returns the externally reachable URL of the named issuer's paths.
+func issuerBaseURL(config *Config, name string) string {
+	return config.BaseURL + "/" + path.Join(issuersPath, url.PathEscape(name))
+}
+
+// Return response data for an issuer
+func (i *Issuer) toResponseData(config *Config, name string) map[string]interface{} {
+	return map[string]interface{}{
+		keyIssuer:     i.issuerURL(config, name),
+		keySigningKey: i.signingKeyName(),
+	}
+}
+
+func pathIssuer(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: issuersPath + "/" + framework.GenericNameRegex(keyIssuerName),
+			Fields: map[string]*framework.FieldSchema{
+				keyIssuerName: {
+					Type:        framework.TypeLowerCaseString,
+					Description: `Specifies the name of the issuer. This is part of the request URL.`,
+					Required:    true,
+				},
+				keyIssuer: {
+					Type: framework.TypeString,
+					Description: `Value to set as the 'iss' claim. Defaults to the issuer's URL under the configured 'base_url',
+which matches its discovery document.`,
+				},
+				keySigningKey: {
+					Type:        framework.TypeLowerCaseString,
+					Description: `Name of the key used to sign tokens of the issuer. Defaults to the 'main' key.`,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathIssuersRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathIssuersWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathIssuersWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathIssuersDelete,
+				},
+			},
+			ExistenceCheck:  b.pathIssuerExistenceCheck,
+			HelpSynopsis:    pathIssuerHelpSyn,
+			HelpDescription: pathIssuerHelpDesc,
+		},
+		{
+			Pattern: issuersPath + "/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathIssuersList,
+				},
+			},
+			HelpSynopsis:    pathIssuerListHelpSyn,
+			HelpDescription: pathIssuerListHelpDesc,
+		},
+		{
+			Pattern: issuersPath + "/" + framework.GenericNameRegex(keyIssuerName) + "/jwks",
+			Fields: map[string]*framework.FieldSchema{
+				keyIssuerName: {
+					Type:        framework.TypeLowerCaseString,
+					Description: `Specifies the name of the issuer. This is part of the request URL.`,
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathIssuerJwksRead,
+				},
+			},
+			HelpSynopsis:    pathIssuerJwksHelpSyn,
+			HelpDescription: pathIssuerJwksHelpDesc,
+		},
+		{
+			Pattern: issuersPath + "/" + framework.GenericNameRegex(keyIssuerName) + "/" + regexp.QuoteMeta(discoveryPath),
+			Fields: map[string]*framework.FieldSchema{
+				keyIssuerName: {
+					Type:        framework.TypeLowerCaseString,
+					Description: `Specifies the name of the issuer. This is part of the request URL.`,
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathIssuerDiscoveryRead,
+				},
+			},
+			HelpSynopsis:    pathIssuerDiscoveryHelpSyn,
+			HelpDescription: pathIssuerDiscoveryHelpDesc,
+		},
+	}
+}
+
+func (b *backend) pathIssuerExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	issuer, err := req.Storage.Get(ctx, path.Join(keyStorageIssuerPath, d.Get(keyIssuerName).(string)))
+	if err != nil {
+		return false, err
+	}
+
+	return issuer != nil, nil
+}
+
+// pathIssuersList makes a request to Vault storage to retrieve a list of issuers for the backend
+func (b *backend) pathIssuersList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, keyStorageIssuerPath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathIssuersRead makes a request to Vault storage to read an issuer and return response data
+func (b *backend) pathIssuersRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyIssuerName).(string)
+
+	issuer, err := b.getIssuer(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return nil, nil
+	}
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: issuer.toResponseData(config, name),
+	}, nil
+}
+
+// pathIssuersWrite makes a request to Vault storage to update an issuer based on the attributes passed to the issuer configuration
+func (b *backend) pathIssuersWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyIssuerName).(string)
+
+	issuer, err := b.getIssuer(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		issuer = &Issuer{}
+	}
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if newIssuer, ok := d.GetOk(keyIssuer); ok {
+		issuer.Issuer = newIssuer.(string)
+	}
+
+	if issuer.issuerURL(config, name) == "" {
+		return logical.ErrorResponse("'%s' is required when '%s' is not configured", keyIssuer, keyBaseURL), logical.ErrInvalidRequest
+	}
+
+	if newKey, ok := d.GetOk(keySigningKey); ok {
+		issuer.Key = newKey.(string)
+		key, err := b.getSigningKey(ctx, req.Storage, config, issuer.signingKeyName())
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return logical.ErrorResponse("unknown key %s", issuer.Key), logical.ErrInvalidRequest
+		}
+	}
+
+	if err := b.setIssuer(ctx, req.Storage, name, issuer); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// pathIssuersDelete makes a request to Vault storage to delete an issuer
+func (b *backend) pathIssuersDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyIssuerName).(string)
+
+	roleNames, err := req.Storage.List(ctx, keyStorageRolePath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.IssuerRef == name {
+			return logical.ErrorResponse("issuer is in use by role %s", roleName), logical.ErrInvalidRequest
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, path.Join(keyStorageIssuerPath, name)); err != nil {
+		return nil, fmt.Errorf("error deleting issuer: %w", err)
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathIssuerJwksRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	issuer, err := b.getIssuer(ctx, req.Storage, d.Get(keyIssuerName).(string))
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return logical.ErrorResponse("unknown issuer"), logical.ErrUnsupportedPath
+	}
+
+	return b.jwksResponse(ctx, req, []string{issuer.signingKeyName()})
+}
+
+func (b *backend) pathIssuerDiscoveryRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyIssuerName).(string)
+
+	issuer, err := b.getIssuer(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return logical.ErrorResponse("unknown issuer"), logical.ErrUnsupportedPath
+	}
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BaseURL == "" {
+		return logical.ErrorResponse("discovery requires '%s' to be configured", keyBaseURL), logical.ErrInvalidRequest
+	}
+
+	jwkSet, err := b.getKeysPublicKeys(ctx, req.Storage, []string{issuer.signingKeyName()}, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return discoveryResponse(config, issuer.issuerURL(config, name), issuerBaseURL(config, name)+"/jwks", jwkSet)
+}
+
+// roleIssuer returns the 'iss' claim and the name of the signing key of tokens issued for the role, which
+// are defined by the issuer the role references, if any.
+func (b *backend) roleIssuer(ctx context.Context, stg logical.Storage, config *Config, role *Role) (string, string, error) {
+	if role.IssuerRef == "" {
+		return role.Issuer, role.signingKeyName(), nil
+	}
+
+	issuer, err := b.getIssuer(ctx, stg, role.IssuerRef)
+	if err != nil {
+		return "", "", err
+	}
+	if issuer == nil {
+		return "", "", errutil.UserError{Err: fmt.Sprintf("unknown issuer %s", role.IssuerRef)}
+	}
+
+	issuerURL := issuer.issuerURL(config, role.IssuerRef)
+	if issuerURL == "" {
+		return "", "", errutil.UserError{Err: fmt.Sprintf("issuer %s has no '%s' and '%s' is not configured", role.IssuerRef, keyIssuer, keyBaseURL)}
+	}
+
+	return issuerURL, issuer.signingKeyName(), nil
+}
+
+// getIssuer gets the issuer from the Vault storage API
+func (b *backend) getIssuer(ctx context.Context, stg logical.Storage, name string) (*Issuer, error) {
+	entry, err := stg.Get(ctx, path.Join(keyStorageIssuerPath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var issuer Issuer
+
+	if err := entry.DecodeJSON(&issuer); err != nil {
+		return nil, err
+	}
+	return &issuer, nil
+}
+
+// setIssuer adds the issuer to the Vault storage API
+func (b *backend) setIssuer(ctx context.Context, stg logical.Storage, name string, issuer *Issuer) error {
+	entry, err := logical.StorageEntryJSON(path.Join(keyStorageIssuerPath, name), issuer)
+	if err != nil {
+		return err
+	}
+
+	return stg.Put(ctx, entry)
+}
+
+const pathIssuerHelpSyn = `
+Manages issuers of tokens.
+`
+
+const pathIssuerHelpDesc = `
+Manages issuers of tokens. Roles referencing an issuer (via 'issuer_ref') issue tokens with the issuer's
+'iss' claim, signed with the issuer's key. Each issuer publishes the keys used to sign its tokens at
+'issuers/<name>/jwks', and its discovery document at 'issuers/<name>/.well-known/openid-configuration'.
+
+issuer:           Value to set as the 'iss' claim. Defaults to the issuer's URL under the configured 'base_url'.
+key:              Name of the key used to sign tokens of the issuer. Defaults to the 'main' key.
+`
+
+const pathIssuerListHelpSyn = `
+This endpoint returns a list of available issuers.
+`
+
+const pathIssuerListHelpDesc = `
+This endpoint returns a list of available issuers. Only the issuer names are returned, not any values.
+`
+
+const pathIssuerJwksHelpSyn = `
+Get the JSON Web Key Set of an issuer.
+`
+
+const pathIssuerJwksHelpDesc = `
+Get a JSON Web Key Set containing only the keys used to sign tokens of the issuer.
+`
+
+const pathIssuerDiscoveryHelpSyn = `
+Get the OpenID Connect discovery document of an issuer.
+`
+
+const pathIssuerDiscoveryHelpDesc = `
+Get the OpenID Connect discovery document of an issuer, which advertises the issuer's JWKS and the signing
+algorithms and claims of its tokens. Requires 'base_url' to be configured.
+`
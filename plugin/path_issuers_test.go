@@ -0,0 +1,229 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"testing"
+)
+
+func writeIssuer(b *backend, storage *logical.Storage, name string, data map[string]interface{}) error {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "issuers/" + name,
+		Storage:    *storage,
+		Data:       data,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return nil
+}
+
+func fetchIssuerJWKS(b *backend, storage *logical.Storage, name string) (*jose.JSONWebKeySet, error) {
+	req := &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       "issuers/" + name + "/jwks",
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	jwkSet := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &jwkSet); err != nil {
+		return nil, err
+	}
+
+	return &jwkSet, nil
+}
+
+func TestIssuer(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyBaseURL: "https://vault.example.com/v1/jwt"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeKey(b, storage, "workload-key", map[string]interface{}{keySignatureAlgorithm: "RS256"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeIssuer(b, storage, "workload", map[string]interface{}{keySigningKey: "workload-key"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRoleData(b, storage, role, map[string]interface{}{keyIssuerRef: "workload"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRole(b, storage, "other", "other.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Fatal("token should be valid", diff, resp.Data)
+	}
+
+	claims := resp.Data[keyClaims].(map[string]interface{})
+	if diff := deep.Equal("https://vault.example.com/v1/jwt/issuers/workload", claims["iss"]); diff != nil {
+		t.Error("issuer claim", diff)
+	}
+
+	// Only the issuer's key is published by the issuer
+	jwkSet, err := fetchIssuerJWKS(b, storage, "workload")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(1, len(jwkSet.Keys)); diff != nil {
+		t.Fatal("issuer jwks key count", diff)
+	}
+	if diff := deep.Equal(tokenKeyID(t, token), jwkSet.Keys[0].KeyID); diff != nil {
+		t.Error("issuer jwks key", diff)
+	}
+	if diff := deep.Equal("RS256", jwkSet.Keys[0].Algorithm); diff != nil {
+		t.Error("issuer jwks algorithm", diff)
+	}
+
+	document, err := fetchDiscovery(b, storage, "issuers/workload/"+discoveryPath)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("https://vault.example.com/v1/jwt/issuers/workload", document.Issuer); diff != nil {
+		t.Error("discovery issuer", diff)
+	}
+	if diff := deep.Equal("https://vault.example.com/v1/jwt/issuers/workload/jwks", document.JWKSURI); diff != nil {
+		t.Error("discovery jwks uri", diff)
+	}
+	if diff := deep.Equal([]string{"RS256"}, document.IDTokenSigningAlgValuesSupported); diff != nil {
+		t.Error("discovery algorithms", diff)
+	}
+
+	// Tokens of other issuers are rejected
+	otherToken, err := signToken(b, storage, "other", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = verifyToken(b, storage, role, otherToken)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonInvalidIssuer)
+}
+
+func TestIssuerExplicitIssuer(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeIssuer(b, storage, "workload", map[string]interface{}{}); err == nil {
+		t.Error("issuer without 'issuer' or 'base_url' should have failed")
+	}
+
+	if err := writeIssuer(b, storage, "workload", map[string]interface{}{keyIssuer: "https://issuer.example.com"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleData(b, storage, "tester", map[string]interface{}{keyIssuerRef: "workload"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := readRole(b, storage, "tester")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal("workload", resp.Data[keyIssuerRef]); diff != nil {
+		t.Error("role issuer ref", diff)
+	}
+
+	var claims map[string]interface{}
+	if err := getSignedToken(b, storage, "tester", map[string]interface{}{}, map[string]interface{}{}, &claims, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal("https://issuer.example.com", claims["iss"]); diff != nil {
+		t.Error("issuer claim", diff)
+	}
+
+	// Discovery requires the base url to derive the JWKS URI
+	if _, err := fetchDiscovery(b, storage, "issuers/workload/"+discoveryPath); err == nil {
+		t.Error("discovery without a base url should have failed")
+	}
+}
+
+func TestIssuerInUse(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeKey(b, storage, "workload-key", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeIssuer(b, storage, "workload", map[string]interface{}{keyIssuer: "https://issuer.example.com", keySigningKey: "workload-key"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleData(b, storage, "tester", map[string]interface{}{keyIssuerRef: "workload", keySigningKey: "workload-key"}); err == nil {
+		t.Error("role with an issuer and key should have failed")
+	}
+
+	if err := writeRoleData(b, storage, "tester", map[string]interface{}{keyIssuerRef: "unknown"}); err == nil {
+		t.Error("role with an unknown issuer should have failed")
+	}
+
+	if err := writeRoleData(b, storage, "tester", map[string]interface{}{keyIssuerRef: "workload"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := deleteKey(b, storage, "workload-key"); err == nil {
+		t.Error("deleting a key in use by an issuer should have failed")
+	}
+
+	req := &logical.Request{
+		Operation:  logical.DeleteOperation,
+		Path:       "issuers/workload",
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Error("deleting an issuer in use by a role should have failed")
+	}
+}
@@ -18,13 +18,25 @@ package jwtsecrets
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"gopkg.in/square/go-jose.v2"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func pathJwks(b *backend) *framework.Path {
@@ -43,7 +55,24 @@ func pathJwks(b *backend) *framework.Path {
 
 func (b *backend) pathJwksRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
 
-	jwkSet, err := b.getPublicKeys(ctx, req.Storage, req.MountPoint)
+	keyNames, err := b.listKeyNames(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.jwksResponse(ctx, req, keyNames)
+}
+
+// jwksResponse builds the raw HTTP response publishing the key set of the named keys. The response is
+// cacheable until the next scheduled rotation of any of the keys, and validated by an entity tag of the key set.
+func (b *backend) jwksResponse(ctx context.Context, req *logical.Request, keyNames []string) (*logical.Response, error) {
+
+	jwkSet, err := b.getKeysPublicKeys(ctx, req.Storage, keyNames, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	maxAge, err := b.jwksMaxAge(ctx, req.Storage, keyNames, req.MountPoint)
 	if err != nil {
 		return nil, err
 	}
@@ -53,66 +82,242 @@ func (b *backend) pathJwksRead(ctx context.Context, req *logical.Request, _ *fra
 		return nil, err
 	}
 
-	return &logical.Response{
+	jwkSetHash := sha256.Sum256(jwkSetJson)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(jwkSetHash[:]) + `"`
+
+	resp := &logical.Response{
 		Data: map[string]interface{}{
-			logical.HTTPStatusCode:  200,
-			logical.HTTPContentType: "application/jwk-set+json",
-			logical.HTTPRawBody:     jwkSetJson,
+			logical.HTTPStatusCode:         http.StatusOK,
+			logical.HTTPContentType:        "application/jwk-set+json",
+			logical.HTTPRawBody:            jwkSetJson,
+			logical.HTTPCacheControlHeader: fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second)),
+		},
+		Headers: map[string][]string{
+			"ETag": {etag},
 		},
-	}, nil
+	}
+
+	if etagMatches(req.Headers["If-None-Match"], etag) {
+		resp.Data[logical.HTTPStatusCode] = http.StatusNotModified
+		resp.Data[logical.HTTPRawBody] = []byte{}
+	}
+
+	return resp, nil
 }
 
-// GetPublicKeys returns a set of JSON Web Keys.
+// jwksMaxAge returns how long the key set of the named keys can be cached for, which is until the next scheduled
+// rotation publishes a new key version, bounded by the configured maximum.
+func (b *backend) jwksMaxAge(ctx context.Context, stg logical.Storage, keyNames []string, mount string) (time.Duration, error) {
+
+	config, err := b.getConfig(ctx, stg)
+	if err != nil {
+		return 0, err
+	}
+
+	maxAge := config.jwksMaxAge()
+	now := time.Now()
+
+	for _, keyName := range keyNames {
+
+		key, err := b.getSigningKey(ctx, stg, config, keyName)
+		if err != nil {
+			return 0, err
+		}
+		if key == nil {
+			continue
+		}
+
+		policy, err := b.getPolicy(ctx, stg, keyName, key, mount)
+		if err != nil {
+			return 0, err
+		}
+
+		policy.Lock(false)
+		rotatesAt, ok := nextRotation(policy, key)
+		policy.Unlock()
+
+		if ok {
+			maxAge = durationMin(maxAge, durationMax(rotatesAt.Sub(now), 0))
+		}
+	}
+
+	return maxAge, nil
+}
+
+// etagMatches reports whether any entity tag of the 'If-None-Match' header values matches the entity tag,
+// using the weak comparison required for 'If-None-Match'.
+func etagMatches(ifNoneMatch []string, etag string) bool {
+	for _, value := range ifNoneMatch {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetPublicKeys returns a set of JSON Web Keys for all signing keys.
 func (b *backend) getPublicKeys(ctx context.Context, stg logical.Storage, mount string) (*jose.JSONWebKeySet, error) {
 
+	keyNames, err := b.listKeyNames(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.getKeysPublicKeys(ctx, stg, keyNames, mount)
+}
+
+// getKeysPublicKeys returns a set of JSON Web Keys for the named signing keys.
+func (b *backend) getKeysPublicKeys(ctx context.Context, stg logical.Storage, keyNames []string, mount string) (*jose.JSONWebKeySet, error) {
+
 	config, err := b.getConfig(ctx, stg)
 	if err != nil {
 		return nil, err
 	}
 
-	policy, err := b.getPolicy(ctx, stg, config, mount)
+	keyIds, err := b.keyIdFormatter(ctx, stg, config, mount)
 	if err != nil {
 		return nil, err
 	}
 
+	jwkSet := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{},
+	}
+
+	for _, keyName := range keyNames {
+
+		key, err := b.getSigningKey(ctx, stg, config, keyName)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			continue
+		}
+
+		policy, err := b.getPolicy(ctx, stg, keyName, key, mount)
+		if err != nil {
+			return nil, err
+		}
+
+		policyKeys, err := b.policyPublicKeys(ctx, stg, policy, key, keyIds)
+		if err != nil {
+			return nil, err
+		}
+
+		jwkSet.Keys = append(jwkSet.Keys, policyKeys...)
+	}
+
+	return &jwkSet, nil
+}
+
+// policyPublicKeys returns the JSON Web Keys for the available versions of a key's policy.
+func (b *backend) policyPublicKeys(ctx context.Context, stg logical.Storage, policy *keysutil.Policy, key *Key, keyIds *keyIdFormatter) ([]jose.JSONWebKey, error) {
+
 	policy.Lock(false)
 	defer policy.Unlock()
 
-	keyCount := (policy.LatestVersion - policy.MinDecryptionVersion) + 1
+	history, err := b.getKeyHistory(ctx, stg, policy.Name)
+	if err != nil {
+		return nil, err
+	}
 
-	jwkSet := jose.JSONWebKeySet{
-		Keys: make([]jose.JSONWebKey, keyCount),
+	revocations, err := b.getKeyRevocations(ctx, stg, policy.Name)
+	if err != nil {
+		return nil, err
 	}
 
-	keyIdx := 0
+	jwks := make([]jose.JSONWebKey, 0, (policy.LatestVersion-policy.MinDecryptionVersion)+1)
+
 	for version := policy.MinDecryptionVersion; version <= policy.LatestVersion; version++ {
 
-		key, ok := policy.Keys[strconv.Itoa(version)]
+		keyVersion, ok := policy.Keys[strconv.Itoa(version)]
 		if !ok {
 			continue
 		}
 
-		if key.FormattedPublicKey != "" {
-			block, _ := pem.Decode([]byte(key.FormattedPublicKey))
-			if block == nil {
-				continue
-			}
+		if _, revoked := revocations.find(version); revoked {
+			continue
+		}
 
-			jwkSet.Keys[keyIdx].Key, err = x509.ParsePKIXPublicKey(block.Bytes)
-			if err != nil {
-				continue
-			}
-		} else if key.RSAKey != nil {
-			jwkSet.Keys[keyIdx].Key = &key.RSAKey.PublicKey
+		publicKey, err := versionPublicKey(keyVersion)
+		if err != nil {
+			b.Logger().Warn(fmt.Sprintf("Unable to publish key: mount=%s, key=%s, version=%d, error=%s", keyIds.Mount, policy.Name, version, err))
+			continue
+		}
+
+		kid, err := keyIds.keyId(policy.Name, version, &keyVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		sigAlg, ok := history.algorithm(version)
+		if !ok {
+			sigAlg = versionAlgorithm(publicKey, key.SignatureAlgorithm)
 		}
 
-		jwkSet.Keys[keyIdx].KeyID = createKeyId(b.id, policy.Name, version)
-		jwkSet.Keys[keyIdx].Algorithm = string(config.SignatureAlgorithm)
-		jwkSet.Keys[keyIdx].Use = "sig"
-		keyIdx += 1
+		jwks = append(jwks, jose.JSONWebKey{
+			Key:       publicKey,
+			KeyID:     kid,
+			Algorithm: string(sigAlg),
+			Use:       "sig",
+		})
 	}
 
-	return &jwkSet, nil
+	return jwks, nil
+}
+
+// versionPublicKey extracts the public key from a policy key version.
+func versionPublicKey(key keysutil.KeyEntry) (crypto.PublicKey, error) {
+	switch {
+	case key.RSAKey != nil:
+		return &key.RSAKey.PublicKey, nil
+	case key.RSAPublicKey != nil:
+		return key.RSAPublicKey, nil
+	case key.FormattedPublicKey == "":
+		return nil, errors.New("no public key available")
+	}
+
+	// EC public keys are PEM encoded, Ed25519 public keys are raw base64 encoded
+	if block, _ := pem.Decode([]byte(key.FormattedPublicKey)); block != nil {
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	rawKey, err := base64.StdEncoding.DecodeString(key.FormattedPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rawKey) != ed25519.PublicKeySize {
+		return nil, errors.New("unrecognized public key format")
+	}
+
+	return ed25519.PublicKey(rawKey), nil
+}
+
+// versionAlgorithm determines the signature algorithm of a key version without a recorded key format.
+// Versions generated before a key format change may not be compatible with the configured algorithm,
+// in which case the algorithm is derived from the key itself.
+func versionAlgorithm(publicKey crypto.PublicKey, configured jose.SignatureAlgorithm) jose.SignatureAlgorithm {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch configured {
+		case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+			return configured
+		}
+		return jose.RS256
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().BitSize {
+		case 384:
+			return jose.ES384
+		case 521:
+			return jose.ES512
+		}
+		return jose.ES256
+	case ed25519.PublicKey:
+		return jose.EdDSA
+	}
+	return configured
 }
 
 const pathJwksHelpSyn = `
@@ -121,4 +326,7 @@ Get a JSON Web Key Set.
 
 const pathJwksHelpDesc = `
 Get a JSON Web Key Set.
+
+The response can be cached until the next scheduled key rotation, bounded by the configured 'jwks_max_age',
+and carries an 'ETag' header; requests with a matching 'If-None-Match' header receive a 304 response.
 `
@@ -18,8 +18,14 @@ package jwtsecrets
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"testing"
 
 	"github.com/go-test/deep"
@@ -93,3 +99,345 @@ func TestJwks(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestJwksKeyFormatRotation(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	err := writeRole(b, storage, "tester", "tester.example.com", map[string]interface{}{}, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	if _, err := FetchJWKS(b, storage); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keySignatureAlgorithm: "EdDSA"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 2); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+
+	// Previous version keeps the algorithm it was generated for
+	if _, ok := jwkSet.Keys[0].Key.(*ecdsa.PublicKey); !ok {
+		t.Errorf("expected ECDSA key, got %T", jwkSet.Keys[0].Key)
+	}
+	if diff := deep.Equal(jwkSet.Keys[0].Algorithm, "ES256"); diff != nil {
+		t.Error("previous key algorithm", diff)
+	}
+
+	if _, ok := jwkSet.Keys[1].Key.(ed25519.PublicKey); !ok {
+		t.Errorf("expected Ed25519 key, got %T", jwkSet.Keys[1].Key)
+	}
+	if diff := deep.Equal(jwkSet.Keys[1].Algorithm, "EdDSA"); diff != nil {
+		t.Error("latest key algorithm", diff)
+	}
+}
+
+func TestJwksRSAPaddingRotation(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keySignatureAlgorithm: "RS256"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	role := "tester"
+
+	err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keySignatureAlgorithm: "PS256"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	// Versions are ES256 (initial), RS256 & PS256
+	if diff := deep.Equal(len(jwkSet.Keys), 3); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+	if diff := deep.Equal(jwkSet.Keys[0].Algorithm, "ES256"); diff != nil {
+		t.Error("initial key algorithm", diff)
+	}
+	if diff := deep.Equal(jwkSet.Keys[1].Algorithm, "RS256"); diff != nil {
+		t.Error("previous key algorithm", diff)
+	}
+	if diff := deep.Equal(jwkSet.Keys[2].Algorithm, "PS256"); diff != nil {
+		t.Error("latest key algorithm", diff)
+	}
+
+	// Tokens signed before the change still verify
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff, resp.Data)
+	}
+}
+
+func TestJwksThumbprintKeyIds(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: KeyIdFormatThumbprint}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Rotate to a different key type, thumbprints are computed for each
+	if _, err := writeConfig(b, storage, map[string]interface{}{keySignatureAlgorithm: "EdDSA"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 2); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+
+	for _, jwk := range jwkSet.Keys {
+		thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if diff := deep.Equal(base64.RawURLEncoding.EncodeToString(thumbprint), jwk.KeyID); diff != nil {
+			t.Error("kid should be the key thumbprint", diff)
+		}
+	}
+
+	if diff := deep.Equal(jwkSet.Keys[0].KeyID, tokenKeyID(t, token)); diff != nil {
+		t.Error("token kid", diff)
+	}
+
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff, resp.Data)
+	}
+}
+
+func TestJwksTemplateKeyIds(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: "{{mount}}-{{key}}-{{version}}"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	if err := writeKey(b, storage, "other", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("test-main-1", tokenKeyID(t, token)); diff != nil {
+		t.Error("token kid", diff)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	kids := []string{}
+	for _, jwk := range jwkSet.Keys {
+		kids = append(kids, jwk.KeyID)
+	}
+	if diff := deep.Equal([]string{"test-main-1", "test-other-1"}, kids); diff != nil {
+		t.Error("jwks kids", diff)
+	}
+}
+
+func TestJwksKidFormatChange(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%s\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	legacyKid := tokenKeyID(t, token)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: "{{mount}}-{{key}}-{{version}}"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	// Existing versions keep their key ids, so tokens they signed continue to verify
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff, resp.Data)
+	}
+
+	token, err = signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(legacyKid, tokenKeyID(t, token)); diff != nil {
+		t.Error("token kid before rotation", diff)
+	}
+
+	// New versions use the new format
+	if err := rotateKey(b, storage, mainKeyName); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err = signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal("test-main-2", tokenKeyID(t, token)); diff != nil {
+		t.Error("token kid after rotation", diff)
+	}
+
+	// Changing the format again keeps the ids of both versions
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: KeyIdFormatThumbprint}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	kids := []string{}
+	for _, jwk := range jwkSet.Keys {
+		kids = append(kids, jwk.KeyID)
+	}
+	if diff := deep.Equal([]string{legacyKid, "test-main-2"}, kids); diff != nil {
+		t.Error("jwks kids", diff)
+	}
+
+	resp, err = verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff, resp.Data)
+	}
+}
+
+func fetchJWKSResponse(b *backend, storage *logical.Storage, ifNoneMatch string) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       "jwks",
+		Storage:    *storage,
+		MountPoint: "test",
+		Headers:    map[string][]string{},
+	}
+	if ifNoneMatch != "" {
+		req.Headers["If-None-Match"] = []string{ifNoneMatch}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return resp, nil
+}
+
+func TestJwksCaching(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyRotationDuration: "10m", keyPrepublishDuration: "1m"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	resp, err := fetchJWKSResponse(b, storage, "")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Cacheable until the next key is published, 9 minutes after the first key was created
+	var maxAge int
+	if _, err := fmt.Sscanf(resp.Data[logical.HTTPCacheControlHeader].(string), "public, max-age=%d", &maxAge); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if maxAge < 530 || maxAge > 540 {
+		t.Errorf("unexpected max-age %d", maxAge)
+	}
+
+	etag := resp.Headers["ETag"][0]
+
+	resp, err = fetchJWKSResponse(b, storage, "W/"+etag)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(http.StatusNotModified, resp.Data[logical.HTTPStatusCode]); diff != nil {
+		t.Error("matching etag status", diff)
+	}
+	if diff := deep.Equal([]byte{}, resp.Data[logical.HTTPRawBody]); diff != nil {
+		t.Error("not modified body", diff)
+	}
+
+	// Bounded by the configured maximum
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyJwksMaxAge: "1m"}); err != nil {
+		t.Fatalf("err:%s\n", err)
+	}
+
+	if err := rotateKey(b, storage, mainKeyName); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = fetchJWKSResponse(b, storage, etag)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(http.StatusOK, resp.Data[logical.HTTPStatusCode]); diff != nil {
+		t.Error("changed key set status", diff)
+	}
+	if diff := deep.Equal("public, max-age=60", resp.Data[logical.HTTPCacheControlHeader]); diff != nil {
+		t.Error("bounded cache control", diff)
+	}
+	if resp.Headers["ETag"][0] == etag {
+		t.Error("etag should change when the key set changes")
+	}
+}
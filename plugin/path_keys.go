@@ -0,0 +1,522 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"path"
+	"time"
+)
+
+const (
+	keyStorageKeyPath = "key"
+	keyKeyName        = "name"
+)
+
+// Key defines a named signing key.
+type Key struct {
+
+	// SignatureAlgorithm is the signing algorithm to use.
+	SignatureAlgorithm jose.SignatureAlgorithm
+
+	// RSAKeyBits is size of generated RSA keys; only used when SignatureAlgorithm is one of the supported RSA algorithms.
+	RSAKeyBits int
+
+	// RotationPeriod is how frequently a new key version is created.
+	RotationPeriod time.Duration
+
+	// PrepublishPeriod is how long a new key version is published before it is used to sign new tokens.
+	PrepublishPeriod time.Duration
+
+	// VerificationKeyRetention is how long a key version remains available for verification after it stops
+	// signing new tokens. Versions are always retained until all tokens signed with them have expired.
+	// When nil, the config's retention applies.
+	VerificationKeyRetention *time.Duration
+
+	// MinVerificationKeys is the minimum number of key versions retained for verification, regardless
+	// of their age. When zero, the config's minimum applies.
+	MinVerificationKeys int
+}
+
+// Return response data for a key
+func (k *Key) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		keySignatureAlgorithm:       k.SignatureAlgorithm,
+		keyRSAKeyBits:               k.RSAKeyBits,
+		keyRotationDuration:         k.RotationPeriod.String(),
+		keyPrepublishDuration:       k.PrepublishPeriod.String(),
+		keyVerificationKeyRetention: k.verificationKeyRetention().String(),
+		keyMinVerificationKeys:      k.MinVerificationKeys,
+	}
+	return respData
+}
+
+func pathKey(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "keys/" + framework.GenericNameRegex(keyKeyName),
+			Fields: map[string]*framework.FieldSchema{
+				keyKeyName: {
+					Type:        framework.TypeLowerCaseString,
+					Description: `Specifies the name of the key. This is part of the request URL.`,
+					Required:    true,
+				},
+				keySignatureAlgorithm: {
+					Type:        framework.TypeString,
+					Description: `Signature algorithm used to sign new tokens.`,
+				},
+				keyRSAKeyBits: {
+					Type:        framework.TypeInt,
+					Description: `Size of generated RSA keys, when signature algorithm is one of the allowed RSA signing algorithm.`,
+				},
+				keyRotationDuration: {
+					Type:        framework.TypeString,
+					Description: `Duration a specific key version will be used to sign new tokens.`,
+				},
+				keyPrepublishDuration: {
+					Type:        framework.TypeString,
+					Description: `Duration a new key version is published before it is used to sign new tokens.`,
+				},
+				keyVerificationKeyRetention: {
+					Type: framework.TypeString,
+					Description: `Duration a key version remains available for verification after it stops signing new tokens.
+Versions are always retained until all tokens signed with them have expired. Defaults to the config's value.`,
+				},
+				keyMinVerificationKeys: {
+					Type:        framework.TypeInt,
+					Description: `Minimum number of key versions retained for verification. Defaults to the config's value.`,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathKeysRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathKeysWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathKeysWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathKeysDelete,
+				},
+			},
+			ExistenceCheck:  b.pathKeyExistenceCheck,
+			HelpSynopsis:    pathKeyHelpSyn,
+			HelpDescription: pathKeyHelpDesc,
+		},
+		{
+			Pattern: "keys/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathKeysList,
+				},
+			},
+			HelpSynopsis:    pathKeyListHelpSyn,
+			HelpDescription: pathKeyListHelpDesc,
+		},
+	}
+}
+
+func (b *backend) pathKeyExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	name := d.Get(keyKeyName).(string)
+
+	if name == mainKeyName {
+		return true, nil
+	}
+
+	key, err := req.Storage.Get(ctx, path.Join(keyStorageKeyPath, name))
+	if err != nil {
+		return false, err
+	}
+
+	return key != nil, nil
+}
+
+// pathKeysList makes a request to Vault storage to retrieve a list of keys for the backend
+func (b *backend) pathKeysList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	keyNames, err := b.listKeyNames(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(keyNames), nil
+}
+
+// pathKeysRead makes a request to Vault storage to read a key and return response data
+func (b *backend) pathKeysRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	name := d.Get(keyKeyName).(string)
+
+	key, err := b.getSigningKey(ctx, req.Storage, config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return nil, nil
+	}
+
+	policy, err := b.getPolicy(ctx, req.Storage, name, key, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := b.keyVersions(ctx, req.Storage, key, policy, config, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	respData := key.toResponseData()
+	respData[keyLatestVersion] = policy.LatestVersion
+	respData[keyImported] = policy.Imported
+	respData[keyAllowRotation] = !policy.Imported || policy.AllowImportedKeyRotation
+	respData[keyVersions] = versions
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+// pathKeysWrite makes a request to Vault storage to update a key based on the attributes passed to the key configuration
+func (b *backend) pathKeysWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyKeyName).(string)
+
+	if name == mainKeyName {
+		return logical.ErrorResponse("'%s' key is configured via the config", mainKeyName), logical.ErrInvalidRequest
+	}
+
+	key, err := b.getKey(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var previousKey *Key
+	if key == nil {
+		config, err := b.getConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if !keyIdFormatDistinguishesKeys(config.KidFormat) {
+			return logical.ErrorResponse("configured '%s' must contain %s to create additional keys", keyKidFormat, keyIdTemplateKey), logical.ErrInvalidRequest
+		}
+
+		defaultKeyRotationPeriod, _ := time.ParseDuration(DefaultKeyRotationPeriod)
+
+		key = &Key{}
+		key.SignatureAlgorithm = DefaultSignatureAlgorithm
+		key.RSAKeyBits = DefaultRSAKeyBits
+		key.RotationPeriod = defaultKeyRotationPeriod
+	} else {
+		previousKey = key.copy()
+	}
+
+	if newRawSignatureAlgorithmName, ok := d.GetOk(keySignatureAlgorithm); ok {
+		newSignatureAlgorithmName := newRawSignatureAlgorithmName.(string)
+		if !stringInSlice(newSignatureAlgorithmName, AllowedSignatureAlgorithmNames) {
+			return logical.ErrorResponse("unknown/unsupported signature algorithm, must be one of %s", AllowedSignatureAlgorithmNames), logical.ErrInvalidRequest
+		}
+		key.SignatureAlgorithm = jose.SignatureAlgorithm(newSignatureAlgorithmName)
+	}
+
+	if newRSAKeyBits, ok := d.GetOk(keyRSAKeyBits); ok {
+		if !intInSlice(newRSAKeyBits.(int), AllowedRSAKeyBits) {
+			return logical.ErrorResponse("unsupported rsa_key_bits, must be one of %s", AllowedRSAKeyBits), logical.ErrInvalidRequest
+		}
+		key.RSAKeyBits = newRSAKeyBits.(int)
+	}
+
+	if newRotationPeriod, ok := d.GetOk(keyRotationDuration); ok {
+		duration, err := time.ParseDuration(newRotationPeriod.(string))
+		if err != nil {
+			return nil, err
+		}
+		key.RotationPeriod = duration
+	}
+
+	if newPrepublishPeriod, ok := d.GetOk(keyPrepublishDuration); ok {
+		duration, err := time.ParseDuration(newPrepublishPeriod.(string))
+		if err != nil {
+			return nil, err
+		}
+		key.PrepublishPeriod = duration
+	}
+
+	if key.PrepublishPeriod < 0 || key.PrepublishPeriod >= key.RotationPeriod {
+		return logical.ErrorResponse("'%s' must be less than '%s'", keyPrepublishDuration, keyRotationDuration), logical.ErrInvalidRequest
+	}
+
+	if newRetention, ok := d.GetOk(keyVerificationKeyRetention); ok {
+		duration, err := time.ParseDuration(newRetention.(string))
+		if err != nil {
+			return nil, err
+		}
+		if duration < 0 {
+			return logical.ErrorResponse("'%s' cannot be negative", keyVerificationKeyRetention), logical.ErrInvalidRequest
+		}
+		key.VerificationKeyRetention = &duration
+	}
+
+	if newMinVerificationKeys, ok := d.GetOk(keyMinVerificationKeys); ok {
+		if newMinVerificationKeys.(int) < 1 {
+			return logical.ErrorResponse("'%s' must be at least 1", keyMinVerificationKeys), logical.ErrInvalidRequest
+		}
+		key.MinVerificationKeys = newMinVerificationKeys.(int)
+	}
+
+	if err := b.setKey(ctx, req.Storage, name, key); err != nil {
+		return nil, err
+	}
+
+	keyFormatChanged :=
+		previousKey != nil &&
+			(key.SignatureAlgorithm != previousKey.SignatureAlgorithm ||
+				key.RSAKeyBits != previousKey.RSAKeyBits)
+
+	if keyFormatChanged {
+		if err := b.rotateKeyFormat(ctx, req.Storage, name, previousKey, key, req.MountPoint); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// pathKeysDelete makes a request to Vault storage to delete a key and all of its versions
+func (b *backend) pathKeysDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyKeyName).(string)
+
+	if name == mainKeyName {
+		return logical.ErrorResponse("'%s' key cannot be deleted", mainKeyName), logical.ErrInvalidRequest
+	}
+
+	roleNames, err := req.Storage.List(ctx, keyStorageRolePath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.signingKeyName() == name {
+			return logical.ErrorResponse("key is in use by role %s", roleName), logical.ErrInvalidRequest
+		}
+	}
+
+	issuerNames, err := req.Storage.List(ctx, keyStorageIssuerPath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, issuerName := range issuerNames {
+		issuer, err := b.getIssuer(ctx, req.Storage, issuerName)
+		if err != nil {
+			return nil, err
+		}
+		if issuer != nil && issuer.signingKeyName() == name {
+			return logical.ErrorResponse("key is in use by issuer %s", issuerName), logical.ErrInvalidRequest
+		}
+	}
+
+	if err := b.deleteKeyVersions(ctx, req.Storage, name); err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Delete(ctx, path.Join(keyStorageKeyPath, name)); err != nil {
+		return nil, fmt.Errorf("error deleting key: %w", err)
+	}
+
+	return nil, nil
+}
+
+// deleteKeyVersions deletes the policy holding the key's versions, along with their history and revocations.
+func (b *backend) deleteKeyVersions(ctx context.Context, stg logical.Storage, name string) error {
+	policy, _, err := b.lockManager.GetPolicy(ctx, keysutil.PolicyRequest{Storage: stg, Name: name}, nil)
+	if err != nil {
+		return err
+	}
+
+	if policy != nil {
+		policy.Lock(true)
+		policy.DeletionAllowed = true
+		err = policy.Persist(ctx, stg)
+		policy.Unlock()
+		if err != nil {
+			return err
+		}
+
+		if err := b.lockManager.DeletePolicy(ctx, stg, name); err != nil {
+			return fmt.Errorf("error deleting key: %w", err)
+		}
+	}
+
+	if err := stg.Delete(ctx, path.Join(keyHistoryPath, name)); err != nil {
+		return fmt.Errorf("error deleting key: %w", err)
+	}
+
+	if err := stg.Delete(ctx, path.Join(keyRevocationsPath, name)); err != nil {
+		return fmt.Errorf("error deleting key: %w", err)
+	}
+
+	if err := stg.Delete(ctx, path.Join(keyIdHistoryPath, name)); err != nil {
+		return fmt.Errorf("error deleting key: %w", err)
+	}
+
+	return nil
+}
+
+// versionRotatesAt returns when a key version created at the given time stops signing new tokens.
+func (k *Key) versionRotatesAt(created time.Time) time.Time {
+	return created.Add(k.RotationPeriod)
+}
+
+// versionExpiresAt returns when a key version created at the given time is no longer retained for verification.
+func (k *Key) versionExpiresAt(created time.Time, tokenTTL time.Duration) time.Time {
+	return k.versionRotatesAt(created).Add(durationMax(tokenTTL, k.verificationKeyRetention()))
+}
+
+// verificationKeyRetention returns how long key versions are retained for verification after they stop signing.
+func (k *Key) verificationKeyRetention() time.Duration {
+	if k.VerificationKeyRetention == nil {
+		return 0
+	}
+	return *k.VerificationKeyRetention
+}
+
+// minRetainedVersion returns the earliest key version that is always retained, regardless of its age.
+func (k *Key) minRetainedVersion(latestVersion int) int {
+	return intMin(latestVersion, latestVersion-k.MinVerificationKeys+1)
+}
+
+func (k *Key) copy() *Key {
+	kc := *k
+	return &kc
+}
+
+// listKeyNames returns the names of all signing keys, starting with the main key
+func (b *backend) listKeyNames(ctx context.Context, stg logical.Storage) ([]string, error) {
+	entries, err := stg.List(ctx, keyStorageKeyPath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{mainKeyName}, entries...), nil
+}
+
+// getSigningKey gets a signing key by name, with unset values defaulted from the config; the main key
+// is defined by the config
+func (b *backend) getSigningKey(ctx context.Context, stg logical.Storage, config *Config, name string) (*Key, error) {
+	if name == mainKeyName {
+		return config.mainKey(), nil
+	}
+
+	key, err := b.getKey(ctx, stg, name)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	if key.VerificationKeyRetention == nil {
+		retention := config.VerificationKeyRetention
+		key.VerificationKeyRetention = &retention
+	}
+	if key.MinVerificationKeys == 0 {
+		key.MinVerificationKeys = config.MinVerificationKeys
+	}
+
+	return key, nil
+}
+
+// getKey gets the key from the Vault storage API
+func (b *backend) getKey(ctx context.Context, stg logical.Storage, name string) (*Key, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing key name")
+	}
+
+	entry, err := stg.Get(ctx, path.Join(keyStorageKeyPath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var key Key
+
+	if err := entry.DecodeJSON(&key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// setKey adds the key to the Vault storage API
+func (b *backend) setKey(ctx context.Context, stg logical.Storage, name string, key *Key) error {
+	entry, err := logical.StorageEntryJSON(path.Join(keyStorageKeyPath, name), key)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for key")
+	}
+
+	if err := stg.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+const pathKeyHelpSyn = `
+Manages named signing keys.
+`
+
+const pathKeyHelpDesc = `
+Manages named signing keys. Roles select the key used to sign their tokens; the 'main' key is
+defined by the config and used by roles that do not select a key.
+
+Reading a key also returns each available version with its kid, algorithm, creation, rotation and
+expiry times, public key and status (prepublished, signing, verify_only, pending_prune or revoked).
+
+sig_alg:                    Signature algorithm used to sign new tokens.
+rsa_key_bits:               Size of generate RSA keys, when using RSA signature algorithms.
+key_ttl:                    Duration before a key version stops signing new tokens and a new one is generated.
+key_prepublish:             Duration a new key version is published before it is used to sign new tokens.
+verification_key_retention: Duration a key version remains available for verification after it stops
+                            signing new tokens. Defaults to the config's value.
+min_verification_keys:      Minimum number of key versions retained for verification. Defaults to the
+                            config's value.
+`
+
+const pathKeyListHelpSyn = `
+This endpoint returns a list of available keys.
+`
+
+const pathKeyListHelpDesc = `
+This endpoint returns a list of available keys, including the 'main' key. Only the key names are returned, not any values.
+`
@@ -0,0 +1,440 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeKey(b *backend, storage *logical.Storage, name string, data map[string]interface{}) error {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "keys/" + name,
+		Storage:    *storage,
+		Data:       data,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return nil
+}
+
+func readKey(b *backend, storage *logical.Storage, name string) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       "keys/" + name,
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return resp, nil
+}
+
+func deleteKey(b *backend, storage *logical.Storage, name string) error {
+	req := &logical.Request{
+		Operation:  logical.DeleteOperation,
+		Path:       "keys/" + name,
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return nil
+}
+
+func writeRoleWithKey(b *backend, storage *logical.Storage, name string, issuer string, key string) error {
+	req := &logical.Request{
+		Operation:  logical.CreateOperation,
+		Path:       "roles/" + name,
+		Storage:    *storage,
+		Data:       map[string]interface{}{"issuer": issuer, "key": key},
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return nil
+}
+
+func TestCreateKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeKey(b, storage, "edge", map[string]interface{}{
+		keySignatureAlgorithm:       "EdDSA",
+		keyRotationDuration:         "1h",
+		keyVerificationKeyRetention: "24h",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := readKey(b, storage, "edge")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(jose.EdDSA, resp.Data[keySignatureAlgorithm]); diff != nil {
+		t.Error("signature algorithm", diff)
+	}
+	if diff := deep.Equal("1h0m0s", resp.Data[keyRotationDuration]); diff != nil {
+		t.Error("rotation period", diff)
+	}
+	if diff := deep.Equal("24h0m0s", resp.Data[keyVerificationKeyRetention]); diff != nil {
+		t.Error("verification key retention", diff)
+	}
+
+	req := &logical.Request{
+		Operation:  logical.ListOperation,
+		Path:       "keys",
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(resp.Data["keys"], []string{mainKeyName, "edge"}); diff != nil {
+		t.Error("failed to list keys:", diff)
+	}
+}
+
+func TestWriteMainKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeKey(b, storage, mainKeyName, map[string]interface{}{keySignatureAlgorithm: "EdDSA"}); err == nil {
+		t.Fatal("writing the main key should have failed")
+	}
+
+	resp, err := readKey(b, storage, mainKeyName)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(DefaultSignatureAlgorithm, resp.Data[keySignatureAlgorithm]); diff != nil {
+		t.Error("signature algorithm", diff)
+	}
+}
+
+func TestSignWithKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeRoleWithKey(b, storage, "tester", "tester.example.com", "edge"); err == nil {
+		t.Fatal("creating a role with an unknown key should have failed")
+	}
+
+	if err := writeKey(b, storage, "edge", map[string]interface{}{keySignatureAlgorithm: "EdDSA"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleWithKey(b, storage, "tester", "tester.example.com", "edge"); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRole(b, storage, "other", "other.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, "tester", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("EdDSA", parsed.Headers[0].Algorithm); diff != nil {
+		t.Error("token algorithm", diff)
+	}
+
+	// Published keys are the union of all keys
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 2); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+	if diff := deep.Equal(jwkSet.Keys[0].Algorithm, string(DefaultSignatureAlgorithm)); diff != nil {
+		t.Error("main key algorithm", diff)
+	}
+	if diff := deep.Equal(jwkSet.Keys[1].Algorithm, "EdDSA"); diff != nil {
+		t.Error("named key algorithm", diff)
+	}
+
+	resp, err := verifyToken(b, storage, "tester", token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff, resp.Data)
+	}
+}
+
+func TestDeleteKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeKey(b, storage, "edge", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleWithKey(b, storage, "tester", "tester.example.com", "edge"); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := signToken(b, storage, "tester", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := deleteKey(b, storage, "edge"); err == nil {
+		t.Fatal("deleting a key in use should have failed")
+	}
+
+	if err := deleteKey(b, storage, mainKeyName); err == nil {
+		t.Fatal("deleting the main key should have failed")
+	}
+
+	req := &logical.Request{
+		Operation:  logical.DeleteOperation,
+		Path:       "roles/tester",
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := deleteKey(b, storage, "edge"); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if resp, err := readKey(b, storage, "edge"); err != nil || resp != nil {
+		t.Errorf("Should have received empty response but got response: %#v", resp)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 1); diff != nil {
+		t.Error("jwks key count", diff)
+	}
+}
+
+func TestKeyVerificationDefaults(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyVerificationKeyRetention: "48h",
+		keyMinVerificationKeys:      3,
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeKey(b, storage, "edge", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := readKey(b, storage, "edge")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("48h0m0s", resp.Data[keyVerificationKeyRetention]); diff != nil {
+		t.Error("verification key retention", diff)
+	}
+	if diff := deep.Equal(3, resp.Data[keyMinVerificationKeys]); diff != nil {
+		t.Error("min verification keys", diff)
+	}
+
+	if err := writeKey(b, storage, "edge", map[string]interface{}{
+		keyVerificationKeyRetention: "1h",
+		keyMinVerificationKeys:      5,
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = readKey(b, storage, "edge")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("1h0m0s", resp.Data[keyVerificationKeyRetention]); diff != nil {
+		t.Error("verification key retention", diff)
+	}
+	if diff := deep.Equal(5, resp.Data[keyMinVerificationKeys]); diff != nil {
+		t.Error("min verification keys", diff)
+	}
+
+	// An explicit zero retention overrides the config's retention
+	if err := writeKey(b, storage, "edge", map[string]interface{}{
+		keyVerificationKeyRetention: "0s",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = readKey(b, storage, "edge")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("0s", resp.Data[keyVerificationKeyRetention]); diff != nil {
+		t.Error("verification key retention", diff)
+	}
+
+	if err := writeKey(b, storage, "edge", map[string]interface{}{
+		keyMinVerificationKeys: 0,
+	}); err == nil {
+		t.Error("expected error for min verification keys below 1")
+	}
+}
+
+func TestReadKeyVersions(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration:   "1h",
+		keyPrepublishDuration: "30m",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRole(b, storage, "tester", "tester.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, "tester", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := rotateKey(b, storage, mainKeyName); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := readKey(b, storage, mainKeyName)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(2, resp.Data[keyLatestVersion]); diff != nil {
+		t.Error("latest version", diff)
+	}
+
+	versions := resp.Data[keyVersions].(map[string]interface{})
+	if diff := deep.Equal(len(versions), 2); diff != nil {
+		t.Fatal("version count", diff)
+	}
+
+	current := versions["1"].(map[string]interface{})
+	if diff := deep.Equal(KeyStatusSigning, current[keyStatus]); diff != nil {
+		t.Error("current version status", diff)
+	}
+	if diff := deep.Equal(tokenKeyID(t, token), current[keyKeyID]); diff != nil {
+		t.Error("current version kid", diff)
+	}
+	if diff := deep.Equal(string(DefaultSignatureAlgorithm), current[keyAlgorithm]); diff != nil {
+		t.Error("current version algorithm", diff)
+	}
+	if !strings.HasPrefix(current[keyPublicKey].(string), "-----BEGIN PUBLIC KEY-----") {
+		t.Error("current version public key should be PEM encoded", current[keyPublicKey])
+	}
+
+	next := versions["2"].(map[string]interface{})
+	if diff := deep.Equal(KeyStatusPrepublished, next[keyStatus]); diff != nil {
+		t.Error("next version status", diff)
+	}
+
+	if _, err := revokeKey(b, storage, mainKeyName, map[string]interface{}{keyVersion: 1}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = readKey(b, storage, mainKeyName)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	versions = resp.Data[keyVersions].(map[string]interface{})
+	if diff := deep.Equal(KeyStatusRevoked, versions["1"].(map[string]interface{})[keyStatus]); diff != nil {
+		t.Error("revoked version status", diff)
+	}
+	if diff := deep.Equal(KeyStatusSigning, versions["2"].(map[string]interface{})[keyStatus]); diff != nil {
+		t.Error("next version status", diff)
+	}
+}
+
+func TestReadKeyVersionsPendingPrune(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration: "2s",
+		keyTokenTTL:         "1s",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := rotateKey(b, storage, mainKeyName); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	time.Sleep(3 * time.Second)
+
+	// Reading rotates again, leaving the earlier versions expired but not yet pruned
+	resp, err := readKey(b, storage, mainKeyName)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	versions := resp.Data[keyVersions].(map[string]interface{})
+	if diff := deep.Equal(len(versions), 3); diff != nil {
+		t.Fatal("version count", diff)
+	}
+
+	for version, status := range map[string]string{"1": KeyStatusPendingPrune, "2": KeyStatusPendingPrune, "3": KeyStatusSigning} {
+		if diff := deep.Equal(status, versions[version].(map[string]interface{})[keyStatus]); diff != nil {
+			t.Error("version", version, "status", diff)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"time"
+)
+
+const (
+	keyVersion      = "version"
+	keyRevokeReason = "reason"
+	keyRevokedAt    = "revoked_at"
+	keyRevokedBy    = "revoked_by"
+	keyEntityID     = "entity_id"
+)
+
+func pathRevoke(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex(keyKeyName) + "/revoke",
+		Fields: map[string]*framework.FieldSchema{
+			keyKeyName: {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the key",
+				Required:    true,
+			},
+			keyVersion: {
+				Type:        framework.TypeInt,
+				Description: `Version of the key to revoke. Defaults to the latest version.`,
+			},
+			keyRevokeReason: {
+				Type:        framework.TypeString,
+				Description: `Reason the version is being revoked.`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRevokeWrite,
+			},
+		},
+		HelpSynopsis:    pathRevokeHelpSyn,
+		HelpDescription: pathRevokeHelpDesc,
+	}
+}
+
+func (b *backend) pathRevokeWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keyName := d.Get(keyKeyName).(string)
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := b.getSigningKey(ctx, req.Storage, config, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return logical.ErrorResponse("unknown key %s", keyName), logical.ErrInvalidRequest
+	}
+
+	policy, err := b.getPolicy(ctx, req.Storage, keyName, key, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	policy.Lock(true)
+	defer policy.Unlock()
+
+	version := policy.LatestVersion
+	if rawVersion, ok := d.GetOk(keyVersion); ok {
+		version = rawVersion.(int)
+	}
+
+	if version < policy.MinDecryptionVersion || version > policy.LatestVersion {
+		return logical.ErrorResponse("version %d of key %s is not available", version, keyName), logical.ErrInvalidRequest
+	}
+
+	revocations, err := b.getKeyRevocations(ctx, req.Storage, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if _, revoked := revocations.find(version); revoked {
+		return logical.ErrorResponse("version %d of key %s is already revoked", version, keyName), logical.ErrInvalidRequest
+	}
+
+	revocation := keyRevocation{
+		Version:   version,
+		RevokedAt: time.Now(),
+		RevokedBy: req.DisplayName,
+		EntityID:  req.EntityID,
+		Reason:    d.Get(keyRevokeReason).(string),
+	}
+
+	if err := b.recordKeyRevocation(ctx, req.Storage, keyName, revocation); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Warn(fmt.Sprintf("Key Revoked: mount=%s, key=%s, version=%d, by=%s, reason=%s", req.MountPoint, keyName, version, revocation.RevokedBy, revocation.Reason))
+
+	// Generate a replacement when no later version exists; later versions begin signing immediately
+	if version == policy.LatestVersion {
+		// A revoked imported key must be replaced, regardless of whether it allows rotation
+		policy.AllowImportedKeyRotation = true
+
+		if err := b.rotateLocked(ctx, req.Storage, policy, req.MountPoint); err != nil {
+			return nil, err
+		}
+	}
+
+	return &logical.Response{
+		Data: revocation.toResponseData(),
+	}, nil
+}
+
+// Return response data for a revocation
+func (r *keyRevocation) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		keyVersion:      r.Version,
+		keyRevokedAt:    r.RevokedAt.Format(time.RFC3339),
+		keyRevokedBy:    r.RevokedBy,
+		keyEntityID:     r.EntityID,
+		keyRevokeReason: r.Reason,
+	}
+}
+
+const pathRevokeHelpSyn = `
+Revoke a compromised version of a signing key.
+`
+
+const pathRevokeHelpDesc = `
+Revoke a version of the key, defaulting to the latest version. The version is immediately removed
+from the JWKS, tokens signed with it fail verification, and it is never used to sign again. When the
+latest version is revoked a new version is generated, which is used to sign all new tokens.
+
+version: Version of the key to revoke. Defaults to the latest version.
+reason:  Reason the version is being revoked, recorded along with who revoked it and when.
+`
@@ -0,0 +1,197 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+)
+
+func revokeKey(b *backend, storage *logical.Storage, name string, data map[string]interface{}) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "keys/" + name + "/revoke",
+		Storage:     *storage,
+		Data:        data,
+		MountPoint:  "test",
+		DisplayName: "token-operator",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return resp, nil
+}
+
+func TestRevokeKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	revokedKeyID := tokenKeyID(t, token)
+
+	resp, err := revokeKey(b, storage, mainKeyName, map[string]interface{}{keyRevokeReason: "leaked"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(1, resp.Data[keyVersion]); diff != nil {
+		t.Error("revoked version", diff)
+	}
+	if diff := deep.Equal("token-operator", resp.Data[keyRevokedBy]); diff != nil {
+		t.Error("revoked by", diff)
+	}
+	if diff := deep.Equal("leaked", resp.Data[keyRevokeReason]); diff != nil {
+		t.Error("revoke reason", diff)
+	}
+
+	// Revoked version is no longer published
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 1); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+	if len(jwkSet.Key(revokedKeyID)) != 0 {
+		t.Error("revoked key should not be published")
+	}
+
+	resp, err = verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonRevokedKey)
+
+	// New tokens are signed with the replacement version
+	token, err = signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(jwkSet.Keys[0].KeyID, tokenKeyID(t, token)); diff != nil {
+		t.Error("token should be signed with the replacement key", diff)
+	}
+
+	if _, err := revokeKey(b, storage, mainKeyName, map[string]interface{}{keyVersion: 1}); err == nil {
+		t.Error("revoking an already revoked version should have failed")
+	}
+}
+
+func TestRevokePrepublishedKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration:   "1h",
+		keyPrepublishDuration: "30m",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := signToken(b, storage, role, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Next version is published but would not sign until the prepublish period passes
+	if err := rotateKey(b, storage, mainKeyName); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := revokeKey(b, storage, mainKeyName, map[string]interface{}{keyVersion: 1}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 1); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+
+	// Revoked version no longer signs, the next version signs immediately
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(jwkSet.Keys[0].KeyID, tokenKeyID(t, token)); diff != nil {
+		t.Error("token should be signed with the next key", diff)
+	}
+}
+
+func TestRevokeUnavailableVersion(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := revokeKey(b, storage, mainKeyName, map[string]interface{}{keyVersion: 5}); err == nil {
+		t.Error("revoking an unavailable version should have failed")
+	}
+
+	if _, err := revokeKey(b, storage, "unknown", map[string]interface{}{}); err == nil {
+		t.Error("revoking an unknown key should have failed")
+	}
+}
+
+func TestRevokeKeyThumbprint(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyKidFormat: KeyIdFormatThumbprint}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := revokeKey(b, storage, mainKeyName, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonRevokedKey)
+}
@@ -0,0 +1,174 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"time"
+)
+
+const keyJTI = "jti"
+
+func pathRevokeToken(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "revoke",
+		Fields: map[string]*framework.FieldSchema{
+			keyToken: {
+				Type:        framework.TypeString,
+				Description: `Compact serialized JWT to revoke.`,
+			},
+			keyJTI: {
+				Type:        framework.TypeString,
+				Description: `'jti' claim of the token to revoke, in place of the token.`,
+			},
+			keyRevokeReason: {
+				Type:        framework.TypeString,
+				Description: `Reason the token is being revoked.`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRevokeTokenWrite,
+			},
+		},
+		HelpSynopsis:    pathRevokeTokenHelpSyn,
+		HelpDescription: pathRevokeTokenHelpDesc,
+	}
+}
+
+func (b *backend) pathRevokeTokenWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawToken, tokenOk := d.GetOk(keyToken)
+	rawJTI, jtiOk := d.GetOk(keyJTI)
+	if tokenOk == jtiOk {
+		return logical.ErrorResponse("exactly one of '%s' or '%s' is required", keyToken, keyJTI), logical.ErrInvalidRequest
+	}
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	revocation := &tokenRevocation{
+		RevokedAt: now,
+		RevokedBy: req.DisplayName,
+		EntityID:  req.EntityID,
+		Reason:    d.Get(keyRevokeReason).(string),
+	}
+
+	if tokenOk {
+		jti, expiresAt, errResp, err := b.revocableToken(ctx, req, rawToken.(string))
+		if errResp != nil || err != nil {
+			return errResp, err
+		}
+		revocation.JTI = jti
+		revocation.ExpiresAt = expiresAt
+	} else {
+		revocation.JTI = rawJTI.(string)
+		if revocation.JTI == "" {
+			return logical.ErrorResponse("'%s' cannot be empty", keyJTI), logical.ErrInvalidRequest
+		}
+	}
+
+	// Without a verified expiry, the revocation is retained until any token that could have been signed has expired
+	if revocation.ExpiresAt.IsZero() {
+		maxTTL, err := b.maxTokenTTL(ctx, req.Storage, config)
+		if err != nil {
+			return nil, err
+		}
+		revocation.ExpiresAt = now.Add(maxTTL)
+	}
+
+	if err := b.recordTokenRevocation(ctx, req.Storage, revocation); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info(fmt.Sprintf("Token Revoked: mount=%s, jti=%s, by=%s, reason=%s", req.MountPoint, revocation.JTI, revocation.RevokedBy, revocation.Reason))
+
+	return &logical.Response{
+		Data: revocation.toResponseData(),
+	}, nil
+}
+
+// revocableToken verifies the token was signed by the backend and returns its 'jti' claim and expiry, if any.
+func (b *backend) revocableToken(ctx context.Context, req *logical.Request, rawToken string) (string, time.Time, *logical.Response, error) {
+	token, err := jwt.ParseSigned(rawToken)
+	if err != nil {
+		return "", time.Time{}, logical.ErrorResponse("error parsing jwt: %v", err), logical.ErrInvalidRequest
+	}
+	if len(token.Headers) != 1 {
+		return "", time.Time{}, logical.ErrorResponse("expected exactly one signature, found %d", len(token.Headers)), logical.ErrInvalidRequest
+	}
+
+	jwkSet, err := b.getPublicKeys(ctx, req.Storage, req.MountPoint)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	matchingKeys := jwkSet.Key(token.Headers[0].KeyID)
+	if len(matchingKeys) != 1 {
+		return "", time.Time{}, logical.ErrorResponse("no verification key for kid %s", token.Headers[0].KeyID), logical.ErrInvalidRequest
+	}
+
+	var standardClaims jwt.Claims
+	if err := token.Claims(matchingKeys[0].Key, &standardClaims); err != nil {
+		return "", time.Time{}, logical.ErrorResponse("error verifying signature: %v", err), logical.ErrInvalidRequest
+	}
+
+	if standardClaims.ID == "" {
+		return "", time.Time{}, logical.ErrorResponse("token has no 'jti' claim, it cannot be revoked"), logical.ErrInvalidRequest
+	}
+
+	var expiresAt time.Time
+	if standardClaims.Expiry != nil {
+		expiresAt = standardClaims.Expiry.Time()
+	}
+
+	return standardClaims.ID, expiresAt, nil, nil
+}
+
+// Return response data for a token revocation
+func (r *tokenRevocation) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		keyJTI:          r.JTI,
+		keyExpiresAt:    r.ExpiresAt.Format(time.RFC3339),
+		keyRevokedAt:    r.RevokedAt.Format(time.RFC3339),
+		keyRevokedBy:    r.RevokedBy,
+		keyEntityID:     r.EntityID,
+		keyRevokeReason: r.Reason,
+	}
+}
+
+const pathRevokeTokenHelpSyn = `
+Revoke a signed token.
+`
+
+const pathRevokeTokenHelpDesc = `
+Revoke a token signed by this backend, by the token itself or its 'jti' claim. Revoked tokens fail
+verification until they expire, after which their revocation is removed. Revoking the lease of a
+token also revokes the token.
+
+token:  Compact serialized JWT to revoke. Its signature must be valid, and it must have a 'jti' claim.
+jti:    'jti' claim of the token to revoke, in place of the token. The revocation is retained for the
+        longest TTL of any role.
+reason: Reason the token is being revoked, recorded along with who revoked it and when.
+`
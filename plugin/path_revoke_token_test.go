@@ -0,0 +1,225 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+	"time"
+)
+
+func revokeToken(b *backend, storage *logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "revoke",
+		Storage:    *storage,
+		Data:       data,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return resp, nil
+}
+
+func TestRevokeToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{"sub": "Kif Kroker"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	other, err := signToken(b, storage, role, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := revokeToken(b, storage, map[string]interface{}{keyToken: token, keyRevokeReason: "stolen"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal("1", resp.Data[keyJTI]); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal("stolen", resp.Data[keyRevokeReason]); diff != nil {
+		t.Error(diff)
+	}
+
+	resp, err = verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	expectVerifyFailure(t, resp, VerifyReasonRevokedToken)
+
+	// Other tokens remain valid
+	resp, err = verifyToken(b, storage, role, other)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff)
+	}
+
+	// Revoking by jti
+	if _, err := revokeToken(b, storage, map[string]interface{}{keyJTI: "2"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = verifyToken(b, storage, role, other)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	expectVerifyFailure(t, resp, VerifyReasonRevokedToken)
+}
+
+func TestRevokeTokenInvalid(t *testing.T) {
+	b, storage := getTestBackend(t)
+	other, otherStorage := getTestBackend(t)
+
+	if err := writeRole(other, otherStorage, "tester", "tester.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	foreignToken, err := signToken(other, otherStorage, "tester", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	invalidRequests := []map[string]interface{}{
+		{},
+		{keyToken: foreignToken, keyJTI: "1"},
+		{keyToken: "not.a.jwt"},
+		{keyToken: foreignToken},
+		{keyJTI: ""},
+	}
+
+	for _, data := range invalidRequests {
+		if _, err := revokeToken(b, storage, data); err == nil {
+			t.Errorf("revoke request %v should have failed", data)
+		}
+	}
+}
+
+func TestRevokeTokenLease(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, _, err := signTokenData(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	token := resp.Data[keyToken].(string)
+
+	req := &logical.Request{
+		Operation:  logical.RevokeOperation,
+		Storage:    *storage,
+		Secret:     resp.Secret,
+		MountPoint: "test",
+	}
+	if resp, err := b.HandleRequest(context.Background(), req); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	expectVerifyFailure(t, resp, VerifyReasonRevokedToken)
+
+	// Leases that expire with their token don't record a revocation
+	resp, claims, err := signTokenData(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp.Secret.InternalData[internalDataExpiresAt] = time.Now().Add(-time.Second).Format(time.RFC3339)
+
+	req.Secret = resp.Secret
+	if resp, err := b.HandleRequest(context.Background(), req); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	revocation, err := b.getTokenRevocation(context.Background(), *storage, claims["jti"].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if revocation != nil {
+		t.Error("expired token lease should not have recorded a revocation")
+	}
+}
+
+func TestPruneTokenRevocations(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyTokenTTL: "1s"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := revokeToken(b, storage, map[string]interface{}{keyToken: token}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	config, err := b.getConfig(context.Background(), *storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Revocations are retained until the token expires
+	if err := b.pruneTokenRevocations(context.Background(), *storage, config); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if revocation, err := b.getTokenRevocation(context.Background(), *storage, "1"); err != nil || revocation == nil {
+		t.Fatalf("revocation should be retained: %v", err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if err := b.pruneTokenRevocations(context.Background(), *storage, config); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if revocation, err := b.getTokenRevocation(context.Background(), *storage, "1"); err != nil || revocation != nil {
+		t.Fatalf("revocation should be pruned: %v", err)
+	}
+}
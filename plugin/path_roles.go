@@ -20,15 +20,26 @@ import (
 	"context"
 	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/cidrutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"path"
 	"regexp"
+	"strings"
+	"time"
 )
 
 const (
 	keyStorageRolePath = "role"
 	keyRoleName        = "name"
 	keyIssuer          = "issuer"
+	keySigningKey      = "key"
+	keyClaimsSchema    = "claims_schema"
+	keyTTL             = "ttl"
+	keyMaxTTL          = "max_ttl"
+	keyIATBackdate     = "iat_backdate"
+	keyNBFBackdate     = "nbf_backdate"
+	keyMaxNBFDelay     = "max_nbf_delay"
+	keyJtiPrefix       = "jti_prefix"
 )
 
 type Role struct {
@@ -37,6 +48,7 @@ type Role struct {
 	Issuer string
 
 	// Claims defines claim values to be set on the issued JWT; each claim must be allowed by the plugin config.
+	// String values can contain templates, e.g. '{{identity.entity.name}}', resolved from the caller's identity.
 	Claims map[string]interface{} `json:"claims"`
 
 	// SubjectPattern defines a regular expression (https://golang.org/pkg/regexp/) which must be matched by any
@@ -48,8 +60,158 @@ type Role struct {
 	// This restriction is in addition to that defined on the plugin config.
 	AudiencePattern string
 
+	// MaxAudiences defines the maximum number of strings in the 'aud' claim, or -1 for no limit. If nil, only the
+	// limit defined on the plugin config applies; otherwise it cannot exceed that limit.
+	MaxAudiences *int `json:"max_audiences,omitempty"`
+
+	// AllowedClaims defines which claims can be provided to sign requests. If nil, all claims allowed by the plugin
+	// config can be provided; otherwise each claim must also be allowed by the plugin config, and an empty list
+	// allows no claims to be provided. The nil and empty values must both be preserved when stored.
+	AllowedClaims []string `json:"allowed_claims"`
+
+	// ClaimConstraints restricts the values of claims provided during sign requests, by claim name. These
+	// restrictions are in addition to those defined on the plugin config.
+	ClaimConstraints map[string]*ClaimConstraint `json:"claim_constraints"`
+
+	// ClaimsSchema defines a JSON Schema (a subset of draft 2020-12) the complete claim set of the issued JWT,
+	// including the claims generated by the backend, must satisfy.
+	ClaimsSchema string `json:"claims_schema"`
+
 	// Headers defines header values to be set on the issued JWT; each header must be allowed by the plugin config.
 	Headers map[string]interface{} `json:"headers"`
+
+	// Key defines the name of the key used to sign the issued JWT. If empty, the main key is used.
+	Key string `json:"key"`
+
+	// SetIAT overrides whether the backend sets the 'iat' claim, if not nil.
+	SetIAT *bool `json:"set_iat,omitempty"`
+
+	// SetNBF overrides whether the backend sets the 'nbf' claim, if not nil.
+	SetNBF *bool `json:"set_nbf,omitempty"`
+
+	// SetJTI overrides whether the backend generates and sets the 'jti' claim, if not nil.
+	SetJTI *bool `json:"set_jti,omitempty"`
+
+	// IATBackdate defines how far before the time of signing the 'iat' claim is set, to tolerate clock skew.
+	IATBackdate time.Duration `json:"iat_backdate,omitempty"`
+
+	// NBFBackdate defines how far before the time of signing the 'nbf' claim is set, to tolerate clock skew.
+	NBFBackdate time.Duration `json:"nbf_backdate,omitempty"`
+
+	// MaxNBFDelay defines how far in the future sign requests can set the 'nbf' claim. If zero, sign requests
+	// cannot set the 'nbf' claim.
+	MaxNBFDelay time.Duration `json:"max_nbf_delay,omitempty"`
+
+	// IssueLease overrides whether a lease is created for each signed token, if not nil.
+	IssueLease *bool `json:"issue_lease,omitempty"`
+
+	// JtiFormat overrides the format of generated 'jti' claims, if not empty.
+	JtiFormat string `json:"jti_format,omitempty"`
+
+	// JtiPrefix defines a prefix prepended to generated 'jti' claims.
+	JtiPrefix string `json:"jti_prefix,omitempty"`
+
+	// BoundEntityIDs restricts signing to callers with one of the entity ids, if not empty.
+	BoundEntityIDs []string `json:"bound_entity_ids,omitempty"`
+
+	// BoundGroupIDs restricts signing to callers whose entity is a member of one of the groups, if not empty.
+	BoundGroupIDs []string `json:"bound_group_ids,omitempty"`
+
+	// BoundEntityMetadata restricts signing to callers whose entity metadata contains each of the key/value pairs.
+	BoundEntityMetadata map[string]string `json:"bound_entity_metadata,omitempty"`
+
+	// BoundCIDRs restricts signing to requests with a remote address in one of the CIDR blocks, if not empty.
+	BoundCIDRs []string `json:"bound_cidrs,omitempty"`
+
+	// BoundMountAccessors restricts signing to callers whose entity has an alias for one of the auth mounts, by
+	// accessor, if not empty. The auth mount the caller's token was issued by is not checked.
+	BoundMountAccessors []string `json:"bound_mount_accessors,omitempty"`
+
+	// TTL defines how long the issued JWT is valid for, unless requested otherwise. If zero, the TTL defined by the
+	// plugin config is used.
+	TTL time.Duration `json:"ttl,omitempty"`
+
+	// MaxTTL defines the maximum TTL that can be requested for the issued JWT. If zero, the maximum TTL defined by
+	// the plugin config is used. The TTL of issued JWTs never exceeds the maximum TTL defined by the plugin config.
+	MaxTTL time.Duration `json:"max_ttl,omitempty"`
+
+	// IssuerRef defines the name of the issuer that defines the 'iss' claim and signing key of the issued JWT,
+	// in place of Issuer and Key.
+	IssuerRef string `json:"issuer_ref"`
+}
+
+// signingKeyName returns the name of the key used to sign tokens for the role.
+func (r *Role) signingKeyName() string {
+	if r.Key == "" {
+		return mainKeyName
+	}
+	return r.Key
+}
+
+// maxTTL returns the maximum TTL of JWTs issued for the role.
+func (r *Role) maxTTL(config *Config) time.Duration {
+	if r.MaxTTL == 0 {
+		return config.maxTokenTTL()
+	}
+	return durationMin(r.MaxTTL, config.maxTokenTTL())
+}
+
+// tokenTTL returns the TTL of JWTs issued for the role, given the TTL requested by the caller, if any. The TTL is
+// limited to the maximum of the role, the plugin config and the system, in that order.
+func (r *Role) tokenTTL(config *Config, sys logical.SystemView, requestedTTL time.Duration) time.Duration {
+	ttl := requestedTTL
+	if ttl == 0 {
+		ttl = r.TTL
+	}
+	if ttl == 0 {
+		ttl = config.TokenTTL
+	}
+	return durationMin(durationMin(ttl, r.maxTTL(config)), sys.MaxLeaseTTL())
+}
+
+// setIAT returns whether the 'iat' claim is set on JWTs issued for the role.
+func (r *Role) setIAT(config *Config) bool {
+	if r.SetIAT != nil {
+		return *r.SetIAT
+	}
+	return config.SetIAT
+}
+
+// setNBF returns whether the 'nbf' claim is set on JWTs issued for the role.
+func (r *Role) setNBF(config *Config) bool {
+	if r.SetNBF != nil {
+		return *r.SetNBF
+	}
+	return config.SetNBF
+}
+
+// setJTI returns whether the 'jti' claim is generated and set on JWTs issued for the role.
+func (r *Role) setJTI(config *Config) bool {
+	if r.SetJTI != nil {
+		return *r.SetJTI
+	}
+	return config.SetJTI
+}
+
+// issueLease returns whether a lease is created for each token signed for the role.
+func (r *Role) issueLease(config *Config) bool {
+	if r.IssueLease != nil {
+		return *r.IssueLease
+	}
+	return config.issueLease()
+}
+
+// jtiFormat returns the format of 'jti' claims generated for JWTs issued for the role.
+func (r *Role) jtiFormat(config *Config) string {
+	if r.JtiFormat != "" {
+		return r.JtiFormat
+	}
+	return config.JtiFormat
+}
+
+// allowsClaim returns whether the role permits the claim to be provided to sign requests.
+func (r *Role) allowsClaim(claim string) bool {
+	return r.AllowedClaims == nil || stringInSlice(claim, r.AllowedClaims)
 }
 
 // Return response data for a role
@@ -61,6 +223,71 @@ func (r *Role) toResponseData() map[string]interface{} {
 		keySubjectPattern:  r.SubjectPattern,
 		keyAudiencePattern: r.AudiencePattern,
 	}
+	if r.MaxAudiences != nil {
+		respData[keyMaxAllowedAudiences] = *r.MaxAudiences
+	}
+	if r.AllowedClaims != nil {
+		respData[keyAllowedClaims] = r.AllowedClaims
+	}
+	if len(r.ClaimConstraints) > 0 {
+		respData[keyClaimConstraints] = r.ClaimConstraints
+	}
+	if r.ClaimsSchema != "" {
+		respData[keyClaimsSchema] = r.ClaimsSchema
+	}
+	if len(r.BoundEntityIDs) > 0 {
+		respData[keyBoundEntityIDs] = r.BoundEntityIDs
+	}
+	if len(r.BoundGroupIDs) > 0 {
+		respData[keyBoundGroupIDs] = r.BoundGroupIDs
+	}
+	if len(r.BoundEntityMetadata) > 0 {
+		respData[keyBoundEntityMetadata] = r.BoundEntityMetadata
+	}
+	if len(r.BoundCIDRs) > 0 {
+		respData[keyBoundCIDRs] = r.BoundCIDRs
+	}
+	if len(r.BoundMountAccessors) > 0 {
+		respData[keyBoundMountAccessors] = r.BoundMountAccessors
+	}
+	if r.TTL != 0 {
+		respData[keyTTL] = r.TTL.String()
+	}
+	if r.MaxTTL != 0 {
+		respData[keyMaxTTL] = r.MaxTTL.String()
+	}
+	if r.SetIAT != nil {
+		respData[keySetIAT] = *r.SetIAT
+	}
+	if r.SetNBF != nil {
+		respData[keySetNBF] = *r.SetNBF
+	}
+	if r.SetJTI != nil {
+		respData[keySetJTI] = *r.SetJTI
+	}
+	if r.IATBackdate != 0 {
+		respData[keyIATBackdate] = r.IATBackdate.String()
+	}
+	if r.NBFBackdate != 0 {
+		respData[keyNBFBackdate] = r.NBFBackdate.String()
+	}
+	if r.MaxNBFDelay != 0 {
+		respData[keyMaxNBFDelay] = r.MaxNBFDelay.String()
+	}
+	if r.IssueLease != nil {
+		respData[keyIssueLease] = *r.IssueLease
+	}
+	if r.JtiFormat != "" {
+		respData[keyJtiFormat] = r.JtiFormat
+	}
+	if r.JtiPrefix != "" {
+		respData[keyJtiPrefix] = r.JtiPrefix
+	}
+	if r.IssuerRef != "" {
+		respData[keyIssuerRef] = r.IssuerRef
+	} else {
+		respData[keySigningKey] = r.signingKeyName()
+	}
 	return respData
 }
 
@@ -76,11 +303,16 @@ func pathRole(b *backend) []*framework.Path {
 				},
 				keyIssuer: {
 					Type:        framework.TypeString,
-					Description: `Value to set as the 'iss' claim. Required on all roles.`,
+					Description: `Value to set as the 'iss' claim. Required on all roles not referencing an issuer.`,
+				},
+				keyIssuerRef: {
+					Type:        framework.TypeLowerCaseString,
+					Description: `Name of the issuer that defines the 'iss' claim and signing key of issued JWTs.`,
 				},
 				keyClaims: {
-					Type:        framework.TypeMap,
-					Description: `Claims to be set on issued JWTs. Each claim must be allowed by the configuration.`,
+					Type: framework.TypeMap,
+					Description: `Claims to be set on issued JWTs. Each claim must be allowed by the configuration.
+String values can contain templates resolved from the caller's identity, e.g. '{{identity.entity.name}}'.`,
 				},
 				keySubjectPattern: {
 					Type: framework.TypeString,
@@ -100,12 +332,95 @@ Must be less than or equal to the maximum number of allowed audiences defined in
 				keyAllowedClaims: {
 					Type: framework.TypeStringSlice,
 					Description: `Claims which are able to be set in addition to ones generated by the backend.
+Each claim must be allowed by the config. Defaults to the claims allowed by the config.
 Note: 'aud' and 'sub' should be in this list if you would like to set them.`,
+				},
+				keyClaimConstraints: {
+					Type: framework.TypeMap,
+					Description: `Constraints on the values of claims provided during sign requests, by claim name. Each constraint
+may declare a 'type', 'pattern', 'enum', 'min', 'max' and 'max_items'. These restrictions are in addition to
+those defined in the config.`,
+				},
+				keyClaimsSchema: {
+					Type: framework.TypeString,
+					Description: `JSON Schema (a subset of draft 2020-12) the complete claim set of issued JWTs must satisfy,
+including the claims generated by the backend.`,
 				},
 				keyHeaders: {
 					Type:        framework.TypeMap,
 					Description: `Headers to be set on issued JWTs. Each header must be allowed by the configuration.`,
 				},
+				keyTTL: {
+					Type:        framework.TypeDurationSecond,
+					Description: `Duration issued JWTs are valid for, unless requested otherwise. Defaults to the TTL defined in the config.`,
+				},
+				keyMaxTTL: {
+					Type:        framework.TypeDurationSecond,
+					Description: `Maximum duration issued JWTs can be valid for. Defaults to, and cannot exceed, the maximum TTL defined in the config.`,
+				},
+				keySetIAT: {
+					Type:        framework.TypeBool,
+					Description: `Whether or not the backend should generate and set the 'iat' claim. Defaults to the config.`,
+				},
+				keySetNBF: {
+					Type:        framework.TypeBool,
+					Description: `Whether or not the backend should generate and set the 'nbf' claim. Defaults to the config.`,
+				},
+				keySetJTI: {
+					Type:        framework.TypeBool,
+					Description: `Whether or not the backend should generate and set the 'jti' claim. Defaults to the config.`,
+				},
+				keyIATBackdate: {
+					Type:        framework.TypeDurationSecond,
+					Description: `Duration the 'iat' claim is set before the time of signing, to tolerate clock skew.`,
+				},
+				keyNBFBackdate: {
+					Type:        framework.TypeDurationSecond,
+					Description: `Duration the 'nbf' claim is set before the time of signing, to tolerate clock skew.`,
+				},
+				keyMaxNBFDelay: {
+					Type: framework.TypeDurationSecond,
+					Description: `Maximum duration after the time of signing sign requests can set the 'nbf' claim to. Defaults to 0, sign requests cannot set the 'nbf' claim.
+Must be less than the max lease ttl, which tokens with a delayed 'nbf' claim also expire within.`,
+				},
+				keyIssueLease: {
+					Type:        framework.TypeBool,
+					Description: `Whether or not the backend should create a lease for each signed token. Defaults to the config.`,
+				},
+				keyJtiFormat: {
+					Type:        framework.TypeString,
+					Description: `Format of generated 'jti' claims: 'friendly', 'uuid', 'uuidv7', 'ulid' or 'random'. Defaults to the config.`,
+				},
+				keyJtiPrefix: {
+					Type:        framework.TypeString,
+					Description: `Prefix prepended to generated 'jti' claims.`,
+				},
+				keyBoundEntityIDs: {
+					Type:        framework.TypeCommaStringSlice,
+					Description: `Entity ids of the callers allowed to sign using the role.`,
+				},
+				keyBoundGroupIDs: {
+					Type:        framework.TypeCommaStringSlice,
+					Description: `Group ids of the callers allowed to sign using the role.`,
+				},
+				keyBoundEntityMetadata: {
+					Type:        framework.TypeKVPairs,
+					Description: `Entity metadata which must be present on the callers allowed to sign using the role.`,
+				},
+				keyBoundCIDRs: {
+					Type:        framework.TypeCommaStringSlice,
+					Description: `CIDR blocks of the remote addresses allowed to sign using the role.`,
+				},
+				keyBoundMountAccessors: {
+					Type: framework.TypeCommaStringSlice,
+					Description: `Accessors of the auth mounts, one of which the callers allowed to sign using the role must have an entity alias for.
+Only the caller's entity aliases are checked, not the auth mount that issued the caller's token; a caller that logged in using
+another auth mount is allowed if its entity also has an alias for a bound mount.`,
+				},
+				keySigningKey: {
+					Type:        framework.TypeLowerCaseString,
+					Description: `Name of the key used to sign issued JWTs. Defaults to the 'main' key.`,
+				},
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.ReadOperation: &framework.PathOperation{
@@ -200,12 +515,36 @@ func (b *backend) pathRolesWrite(ctx context.Context, req *logical.Request, d *f
 
 	createOperation := req.Operation == logical.CreateOperation
 
-	if newIssuer, ok := d.GetOk(keyIssuer); ok {
+	newIssuer, issuerOk := d.GetOk(keyIssuer)
+	newIssuerRef, issuerRefOk := d.GetOk(keyIssuerRef)
+
+	if issuerOk {
 		role.Issuer = newIssuer.(string)
-	} else if !ok && createOperation {
+	} else if !issuerRefOk && createOperation {
 		return nil, fmt.Errorf("missing issuer in role")
 	}
 
+	if issuerRefOk {
+		role.IssuerRef = newIssuerRef.(string)
+		if role.IssuerRef != "" {
+			issuer, err := b.getIssuer(ctx, req.Storage, role.IssuerRef)
+			if err != nil {
+				return nil, err
+			}
+			if issuer == nil {
+				return logical.ErrorResponse("unknown issuer %s", role.IssuerRef), logical.ErrInvalidRequest
+			}
+
+			// Referencing an issuer replaces any issuer and key defined by the role
+			if !issuerOk {
+				role.Issuer = ""
+			}
+			if _, ok := d.GetOk(keySigningKey); !ok {
+				role.Key = ""
+			}
+		}
+	}
+
 	if newClaims, ok := d.GetOk(keyClaims); ok {
 		role.Claims = newClaims.(map[string]interface{})
 	}
@@ -230,6 +569,155 @@ func (b *backend) pathRolesWrite(ctx context.Context, req *logical.Request, d *f
 		}
 	}
 
+	if newMaxAudiences, ok := d.GetOk(keyMaxAllowedAudiences); ok {
+		maxAudiences := newMaxAudiences.(int)
+		if maxAudiences < -1 {
+			return logical.ErrorResponse("'%s' must be -1 or greater", keyMaxAllowedAudiences), logical.ErrInvalidRequest
+		}
+		role.MaxAudiences = &maxAudiences
+	}
+
+	if newAllowedClaims, ok := d.GetOk(keyAllowedClaims); ok {
+		// An empty list allows no claims, rather than inheriting those allowed by the config
+		role.AllowedClaims = append([]string{}, newAllowedClaims.([]string)...)
+	}
+
+	if newClaimConstraints, ok := d.GetOk(keyClaimConstraints); ok {
+		claimConstraints, err := parseClaimConstraints(newClaimConstraints.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		role.ClaimConstraints = claimConstraints
+	}
+
+	if newClaimsSchema, ok := d.GetOk(keyClaimsSchema); ok {
+		role.ClaimsSchema = newClaimsSchema.(string)
+		if role.ClaimsSchema != "" {
+			if _, err := parseJSONSchema(role.ClaimsSchema); err != nil {
+				return logical.ErrorResponse("invalid claims schema: %s", err), logical.ErrInvalidRequest
+			}
+		}
+	}
+
+	if newBoundEntityIDs, ok := d.GetOk(keyBoundEntityIDs); ok {
+		role.BoundEntityIDs = newBoundEntityIDs.([]string)
+	}
+
+	if newBoundGroupIDs, ok := d.GetOk(keyBoundGroupIDs); ok {
+		role.BoundGroupIDs = newBoundGroupIDs.([]string)
+	}
+
+	if newBoundEntityMetadata, ok := d.GetOk(keyBoundEntityMetadata); ok {
+		role.BoundEntityMetadata = newBoundEntityMetadata.(map[string]string)
+	}
+
+	if newBoundCIDRs, ok := d.GetOk(keyBoundCIDRs); ok {
+		role.BoundCIDRs = newBoundCIDRs.([]string)
+		if len(role.BoundCIDRs) > 0 {
+			if _, err := cidrutil.ValidateCIDRListSlice(role.BoundCIDRs); err != nil {
+				return logical.ErrorResponse("invalid '%s': %s", keyBoundCIDRs, err), logical.ErrInvalidRequest
+			}
+		}
+	}
+
+	if newBoundMountAccessors, ok := d.GetOk(keyBoundMountAccessors); ok {
+		role.BoundMountAccessors = newBoundMountAccessors.([]string)
+	}
+
+	if newTTL, ok := d.GetOk(keyTTL); ok {
+		role.TTL = time.Duration(newTTL.(int)) * time.Second
+	}
+
+	if newMaxTTL, ok := d.GetOk(keyMaxTTL); ok {
+		role.MaxTTL = time.Duration(newMaxTTL.(int)) * time.Second
+	}
+
+	if role.TTL < 0 || role.MaxTTL < 0 {
+		return logical.ErrorResponse("'%s' and '%s' cannot be negative", keyTTL, keyMaxTTL), logical.ErrInvalidRequest
+	}
+	if role.MaxTTL != 0 && role.TTL > role.MaxTTL {
+		return logical.ErrorResponse("'%s' cannot be greater than '%s'", keyTTL, keyMaxTTL), logical.ErrInvalidRequest
+	}
+
+	if newSetIAT, ok := d.GetOk(keySetIAT); ok {
+		setIAT := newSetIAT.(bool)
+		role.SetIAT = &setIAT
+	}
+
+	if newSetNBF, ok := d.GetOk(keySetNBF); ok {
+		setNBF := newSetNBF.(bool)
+		role.SetNBF = &setNBF
+	}
+
+	if newSetJTI, ok := d.GetOk(keySetJTI); ok {
+		setJTI := newSetJTI.(bool)
+		role.SetJTI = &setJTI
+	}
+
+	if newIATBackdate, ok := d.GetOk(keyIATBackdate); ok {
+		role.IATBackdate = time.Duration(newIATBackdate.(int)) * time.Second
+	}
+
+	if newNBFBackdate, ok := d.GetOk(keyNBFBackdate); ok {
+		role.NBFBackdate = time.Duration(newNBFBackdate.(int)) * time.Second
+	}
+
+	if newMaxNBFDelay, ok := d.GetOk(keyMaxNBFDelay); ok {
+		role.MaxNBFDelay = time.Duration(newMaxNBFDelay.(int)) * time.Second
+	}
+
+	if newIssueLease, ok := d.GetOk(keyIssueLease); ok {
+		issueLease := newIssueLease.(bool)
+		role.IssueLease = &issueLease
+	}
+
+	if newJtiFormat, ok := d.GetOk(keyJtiFormat); ok {
+		role.JtiFormat = newJtiFormat.(string)
+		if role.JtiFormat != "" && !stringInSlice(role.JtiFormat, AllowedJtiFormats) {
+			return logical.ErrorResponse("unknown '%s' %s, must be one of %s", keyJtiFormat, role.JtiFormat, strings.Join(AllowedJtiFormats, ", ")), logical.ErrInvalidRequest
+		}
+	}
+
+	if newJtiPrefix, ok := d.GetOk(keyJtiPrefix); ok {
+		role.JtiPrefix = newJtiPrefix.(string)
+	}
+
+	if role.IATBackdate < 0 || role.NBFBackdate < 0 || role.MaxNBFDelay < 0 {
+		return logical.ErrorResponse("'%s', '%s' and '%s' cannot be negative", keyIATBackdate, keyNBFBackdate, keyMaxNBFDelay), logical.ErrInvalidRequest
+	}
+
+	if role.MaxNBFDelay >= b.System().MaxLeaseTTL() {
+		return logical.ErrorResponse("'%s' must be less than the max lease ttl", keyMaxNBFDelay), logical.ErrInvalidRequest
+	}
+
+	if newKey, ok := d.GetOk(keySigningKey); ok {
+		role.Key = newKey.(string)
+		key, err := b.getSigningKey(ctx, req.Storage, config, role.signingKeyName())
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return logical.ErrorResponse("unknown key %s", role.Key), logical.ErrInvalidRequest
+		}
+	}
+
+	if role.IssuerRef != "" && (role.Issuer != "" || role.Key != "") {
+		return logical.ErrorResponse("'%s' and '%s' are defined by the issuer, they cannot be set with '%s'", keyIssuer, keySigningKey, keyIssuerRef), logical.ErrInvalidRequest
+	}
+	if role.IssuerRef == "" && role.Issuer == "" {
+		return logical.ErrorResponse("one of '%s' or '%s' is required", keyIssuer, keyIssuerRef), logical.ErrInvalidRequest
+	}
+
+	// Check the role's restrictions only narrow those of the config.
+	if role.MaxAudiences != nil && config.MaxAudiences > -1 && (*role.MaxAudiences == -1 || *role.MaxAudiences > config.MaxAudiences) {
+		return logical.ErrorResponse("'%s' cannot exceed %d, the maximum defined in the config", keyMaxAllowedAudiences, config.MaxAudiences), logical.ErrInvalidRequest
+	}
+	for _, claim := range role.AllowedClaims {
+		if allowedClaim, ok := config.allowedClaimsMap[claim]; !ok || !allowedClaim {
+			return logical.ErrorResponse("claim %s in '%s' not permitted by the config", claim, keyAllowedClaims), logical.ErrInvalidRequest
+		}
+	}
+
 	// Check any provided claims are allowed from the config.
 	for claim := range role.Claims {
 		if allowedClaim, ok := config.allowedClaimsMap[claim]; !ok || !allowedClaim {
@@ -242,9 +730,13 @@ func (b *backend) pathRolesWrite(ctx context.Context, req *logical.Request, d *f
 		return logical.ErrorResponse("'iss' claim cannot be present in 'claims' field"), logical.ErrInvalidRequest
 	}
 
-	// Check that subject claim isn't included in claims field.
-	if _, ok := role.Claims["sub"]; ok {
-		return logical.ErrorResponse("'sub' claim cannot be present in 'claims' field"), logical.ErrInvalidRequest
+	// Check that subject claim isn't included in claims field, unless it's templated from the caller's identity.
+	if sub, ok := role.Claims["sub"]; ok && !hasClaimTemplates(sub) {
+		return logical.ErrorResponse("'sub' claim cannot be present in 'claims' field unless templated from the caller's identity"), logical.ErrInvalidRequest
+	}
+
+	if err := validateClaimTemplates(role.Claims); err != nil {
+		return logical.ErrorResponse("invalid claim template: %s", err), logical.ErrInvalidRequest
 	}
 
 	// If any audience is set in the claims, validate it against the configured restrictions.
@@ -258,6 +750,9 @@ func (b *backend) pathRolesWrite(ctx context.Context, req *logical.Request, d *f
 			if config.MaxAudiences > -1 && len(aud) > config.MaxAudiences {
 				return logical.ErrorResponse("too many audience claims: %d", len(aud)), logical.ErrInvalidRequest
 			}
+			if role.MaxAudiences != nil && *role.MaxAudiences > -1 && len(aud) > *role.MaxAudiences {
+				return logical.ErrorResponse("too many audience claims: %d", len(aud)), logical.ErrInvalidRequest
+			}
 			for _, rawAudEntry := range aud {
 				audEntry, ok := rawAudEntry.(string)
 				if !ok {
@@ -344,6 +839,33 @@ const pathRoleHelpDesc = `
 Manages Vault role for generating tokens.
 
 subject:          Subject claim (sub) for tokens generated using this role.
+claims:           Claims set on tokens generated using this role. String values can contain templates
+                  resolved from the caller's identity: '{{identity.entity.id}}', '{{identity.entity.name}}',
+                  '{{identity.entity.metadata.<key>}}', '{{identity.entity.aliases.<mount accessor>.name}}',
+                  '{{identity.groups.names}}' and '{{identity.groups.ids}}'.
+key:              Name of the key used to sign tokens generated using this role.
+max_audiences:    Maximum number of audiences in tokens generated using this role. Cannot exceed the maximum
+                  defined in the config.
+allowed_claims:   Claims that can be provided when signing tokens using this role. Each claim must be allowed
+                  by the config.
+claims_schema:    JSON Schema (a subset of draft 2020-12) the complete claim set of tokens generated using this
+                  role must satisfy.
+ttl:              Duration tokens generated using this role are valid for, unless requested otherwise.
+max_ttl:          Maximum duration tokens generated using this role can be valid for.
+set_iat:          Overrides whether the issued at claim (iat) is set, as defined in the config.
+set_nbf:          Overrides whether the not before claim (nbf) is set, as defined in the config.
+set_jti:          Overrides whether the JWT id claim (jti) is set, as defined in the config.
+iat_backdate:     Duration the issued at claim (iat) is set before the time of signing.
+nbf_backdate:     Duration the not before claim (nbf) is set before the time of signing.
+max_nbf_delay:    Maximum duration after the time of signing sign requests can set the not before claim (nbf) to.
+issue_lease:      Overrides whether a lease is created for each token, as defined in the config.
+jti_format:       Overrides the format of the JWT id claim (jti), as defined in the config.
+jti_prefix:       Prefix prepended to the JWT id claim (jti).
+bound_*:          Restrict signing to callers with the bound entity ids, group ids, entity metadata, remote
+                  address CIDR blocks and auth mount accessors. Mount accessors are matched against the
+                  aliases of the caller's entity, not the auth mount that issued the caller's token.
+issuer_ref:       Name of the issuer that defines the issuer claim (iss) and signing key of tokens generated
+                  using this role, in place of 'issuer' and 'key'.
 `
 
 const pathRoleListHelpSyn = `
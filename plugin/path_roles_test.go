@@ -48,6 +48,23 @@ func writeRole(b *backend, storage *logical.Storage, name string, issuer string,
 	return nil
 }
 
+func writeRoleData(b *backend, storage *logical.Storage, name string, data map[string]interface{}) error {
+	req := &logical.Request{
+		Operation:  logical.CreateOperation,
+		Path:       "roles/" + name,
+		Storage:    *storage,
+		Data:       data,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return nil
+}
+
 func readRole(b *backend, storage *logical.Storage, name string) (*logical.Response, error) {
 
 	req := &logical.Request{
@@ -269,3 +286,162 @@ func TestDelete(t *testing.T) {
 		t.Errorf("Should have received empty response but got response: %#v", resp)
 	}
 }
+
+func TestCreateInvalidClaimConstraints(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	invalidConstraints := []map[string]interface{}{
+		{"exp": map[string]interface{}{"max": 10}},
+		{"groups": map[string]interface{}{"type": "list"}},
+		{"groups": map[string]interface{}{"pattern": "("}},
+		{"groups": map[string]interface{}{"min": 10, "max": 1}},
+		{"groups": map[string]interface{}{"max_items": -1}},
+		{"groups": map[string]interface{}{"maximum": 1}},
+		{"groups": "admin"},
+	}
+
+	for _, constraints := range invalidConstraints {
+		err := writeRoleData(b, storage, "tester", map[string]interface{}{
+			keyIssuer:           "tester.example.com",
+			keyClaimConstraints: constraints,
+		})
+		if err == nil {
+			t.Errorf("role with claim constraints %v should have failed", constraints)
+		}
+	}
+}
+
+func TestCreateInvalidClaimsSchema(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	invalidSchemas := []string{
+		`not json`,
+		`"object"`,
+		`{"$schema": "http://json-schema.org/draft-07/schema#"}`,
+		`{"type": "date"}`,
+		`{"properties": {"ctx": {"$ref": "#/$defs/ctx"}}}`,
+		`{"properties": {"ctx": {"pattern": "("}}}`,
+		`{"minItems": -1}`,
+	}
+
+	for _, schema := range invalidSchemas {
+		err := writeRoleData(b, storage, "tester", map[string]interface{}{
+			keyIssuer:       "tester.example.com",
+			keyClaimsSchema: schema,
+		})
+		if err == nil {
+			t.Errorf("role with claims schema %s should have failed", schema)
+		}
+	}
+}
+
+func TestCreateRoleRestrictions(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyMaxAllowedAudiences: 3,
+		keyAllowedClaims:       []string{"sub", "aud", "groups"},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	invalidRoles := []map[string]interface{}{
+		{keyMaxAllowedAudiences: 4},
+		{keyMaxAllowedAudiences: -1},
+		{keyMaxAllowedAudiences: -2},
+		{keyAllowedClaims: []string{"sub", "email"}},
+	}
+
+	for _, data := range invalidRoles {
+		data[keyIssuer] = role + ".example.com"
+		if err := writeRoleData(b, storage, role, data); err == nil {
+			t.Errorf("role %v widening the config should have failed", data)
+		}
+	}
+
+	if err := writeRoleData(b, storage, role, map[string]interface{}{
+		keyIssuer:              role + ".example.com",
+		keyMaxAllowedAudiences: 2,
+		keyAllowedClaims:       []string{"aud"},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := readRole(b, storage, role)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(2, resp.Data[keyMaxAllowedAudiences]); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal([]string{"aud"}, resp.Data[keyAllowedClaims]); diff != nil {
+		t.Error(diff)
+	}
+
+	// An empty list is kept, rather than inheriting the claims allowed by the config
+	if err := writeRoleData(b, storage, role, map[string]interface{}{
+		keyIssuer:        role + ".example.com",
+		keyAllowedClaims: []string{},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = readRole(b, storage, role)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal([]string{}, resp.Data[keyAllowedClaims]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCreateInvalidClaimTemplates(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyAllowedClaims: []string{"sub", "name", "groups"}}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	invalidClaims := []map[string]interface{}{
+		{"sub": "Kif Kroker"},
+		{"name": "{{identity.entity.email}}"},
+		{"name": "{{identity.entity.aliases.name}}"},
+		{"name": "{{identity.entity.metadata.}}"},
+		{"name": "{{identity.groups}}"},
+		{"name": "{{request.path}}"},
+		{"groups": "members: {{identity.groups.names}}"},
+	}
+
+	for _, claims := range invalidClaims {
+		if err := writeRole(b, storage, "tester", "tester.example.com", claims, map[string]interface{}{}); err == nil {
+			t.Errorf("role with claims %v should have failed", claims)
+		}
+	}
+}
+
+func TestCreateInvalidBoundCIDRs(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeRoleData(b, storage, "tester", map[string]interface{}{
+		keyIssuer:     "tester.example.com",
+		keyBoundCIDRs: "10.0.0.1",
+	}); err == nil {
+		t.Error("role with invalid bound cidr should have failed")
+	}
+}
+
+func TestCreateInvalidTTL(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeRoleData(b, storage, "tester", map[string]interface{}{
+		keyIssuer: "tester.example.com",
+		keyTTL:    "1h",
+		keyMaxTTL: "10m",
+	}); err == nil {
+		t.Error("role with ttl greater than max ttl should have failed")
+	}
+}
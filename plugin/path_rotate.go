@@ -0,0 +1,90 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathRotate(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex(keyKeyName) + "/rotate",
+		Fields: map[string]*framework.FieldSchema{
+			keyKeyName: {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the key",
+				Required:    true,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRotateWrite,
+			},
+		},
+		HelpSynopsis:    pathRotateHelpSyn,
+		HelpDescription: pathRotateHelpDesc,
+	}
+}
+
+func (b *backend) pathRotateWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keyName := d.Get(keyKeyName).(string)
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := b.getSigningKey(ctx, req.Storage, config, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return logical.ErrorResponse("unknown key %s", keyName), logical.ErrInvalidRequest
+	}
+
+	policy, err := b.getPolicy(ctx, req.Storage, keyName, key, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	policy.Lock(true)
+	defer policy.Unlock()
+
+	if policy.Imported && !policy.AllowImportedKeyRotation {
+		return logical.ErrorResponse("imported key %s does not allow rotation", keyName), logical.ErrInvalidRequest
+	}
+
+	if err := b.rotateLocked(ctx, req.Storage, policy, req.MountPoint); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathRotateHelpSyn = `
+Rotate a signing key.
+`
+
+const pathRotateHelpDesc = `
+Immediately generate a new version of the key and start a new rotation period. The new version is
+published right away and signs all new tokens once it has been published for the key's 'key_prepublish'
+period, or immediately when no pre-publication period is configured. Until then the previous version
+continues to sign. Previous versions remain available for verification as they would after a scheduled
+rotation.
+`
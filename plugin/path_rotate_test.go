@@ -0,0 +1,101 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"testing"
+)
+
+func rotateKey(b *backend, storage *logical.Storage, name string) error {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "keys/" + name + "/rotate",
+		Storage:    *storage,
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return nil
+}
+
+func TestRotateKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := rotateKey(b, storage, mainKeyName); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(len(jwkSet.Keys), 2); diff != nil {
+		t.Fatal("jwks key count", diff)
+	}
+
+	rotatedToken, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	parsed, err := jwt.ParseSigned(rotatedToken)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(jwkSet.Keys[1].KeyID, parsed.Headers[0].KeyID); diff != nil {
+		t.Error("new tokens should be signed with the rotated key", diff)
+	}
+
+	// Tokens signed before the rotation still verify
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid", diff, resp.Data)
+	}
+}
+
+func TestRotateUnknownKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := rotateKey(b, storage, "unknown"); err == nil {
+		t.Fatal("rotating an unknown key should have failed")
+	}
+}
@@ -18,17 +18,23 @@ package jwtsecrets
 
 import (
 	"context"
+	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 	"regexp"
+	"strings"
 	"time"
 )
 
 const (
-	keyClaims  = "claims"
-	keyHeaders = "headers"
+	keyClaims     = "claims"
+	keyHeaders    = "headers"
+	keyNBF        = "nbf"
+	keyExpiresAt  = "expires_at"
+	keyViolations = "violations"
 )
 
 func pathSign(b *backend) *framework.Path {
@@ -45,6 +51,16 @@ func pathSign(b *backend) *framework.Path {
 				Description: `JSON claims set to sign.`,
 				Required:    false,
 			},
+			keyTTL: {
+				Type:        framework.TypeDurationSecond,
+				Description: `Duration the token is valid for. Defaults to the TTL of the role, and is limited to its maximum TTL.`,
+				Required:    false,
+			},
+			keyNBF: {
+				Type:        framework.TypeInt,
+				Description: `Time, in seconds since the epoch, the token becomes valid at (mapped to the 'nbf' claim). Cannot be later than the role's max nbf delay.`,
+				Required:    false,
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
@@ -67,6 +83,21 @@ func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, d *fr
 		return logical.ErrorResponse("unknown role"), logical.ErrInvalidRequest
 	}
 
+	var identity *callerIdentity
+	if role.requiresIdentity() || hasClaimTemplates(role.Claims) {
+		identity, err = b.callerIdentity(req)
+		if err != nil {
+			if userErr, ok := err.(errutil.UserError); ok {
+				return logical.ErrorResponse(userErr.Error()), logical.ErrPermissionDenied
+			}
+			return nil, err
+		}
+	}
+
+	if err := role.checkBindings(req, identity); err != nil {
+		return logical.ErrorResponse("permission denied: %s", err), logical.ErrPermissionDenied
+	}
+
 	// Gather "freeform" claims
 
 	rawClaims, ok := d.GetOk(keyClaims)
@@ -88,36 +119,89 @@ func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, d *fr
 		if allowedClaim, ok := config.allowedClaimsMap[claim]; !ok || !allowedClaim {
 			return logical.ErrorResponse("claim %s not permitted", claim), logical.ErrInvalidRequest
 		}
+		if !role.allowsClaim(claim) {
+			return logical.ErrorResponse("claim %s not permitted (role restriction)", claim), logical.ErrInvalidRequest
+		}
 		if _, ok := role.Claims[claim]; ok {
 			return logical.ErrorResponse("claim %s not permitted, already provided by role", claim), logical.ErrInvalidRequest
 		}
 	}
 
-	for roleClaim := range role.Claims {
-		claims[roleClaim] = role.Claims[roleClaim]
+	if err := checkClaimConstraints(config.ClaimConstraints, claims); err != nil {
+		return logical.ErrorResponse("%s (config restriction)", err), logical.ErrInvalidRequest
+	}
+	if err := checkClaimConstraints(role.ClaimConstraints, claims); err != nil {
+		return logical.ErrorResponse("%s (role restriction)", err), logical.ErrInvalidRequest
+	}
+
+	roleClaims := role.Claims
+	if hasClaimTemplates(roleClaims) {
+		renderedClaims, err := renderClaimTemplates(roleClaims, identity)
+		if err != nil {
+			return logical.ErrorResponse("could not resolve role claims: %s", err), logical.ErrInvalidRequest
+		}
+		roleClaims = renderedClaims.(map[string]interface{})
+	}
+
+	for roleClaim := range roleClaims {
+		claims[roleClaim] = roleClaims[roleClaim]
 	}
 
-	claims["iss"] = role.Issuer
+	issuer, keyName, err := b.roleIssuer(ctx, req.Storage, config, role)
+	if err != nil {
+		if userErr, ok := err.(errutil.UserError); ok {
+			return logical.ErrorResponse(userErr.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	claims["iss"] = issuer
+
+	requestedTTL := time.Duration(d.Get(keyTTL).(int)) * time.Second
+	if requestedTTL < 0 {
+		return logical.ErrorResponse("'%s' cannot be negative", keyTTL), logical.ErrInvalidRequest
+	}
+
+	ttl := role.tokenTTL(config, b.System(), requestedTTL)
 
 	now := time.Now()
 
-	expiry := now.Add(config.TokenTTL)
-	claims["exp"] = jwt.NumericDate(expiry.Unix())
+	// Tokens with a requested 'nbf' claim are valid for their ttl from that time
+	validFrom := now
+
+	if rawNBF, ok := d.GetOk(keyNBF); ok {
+		nbf := time.Unix(int64(rawNBF.(int)), 0)
+		if nbf.Before(now.Truncate(time.Second)) {
+			return logical.ErrorResponse("'%s' cannot be in the past", keyNBF), logical.ErrInvalidRequest
+		}
+		if nbf.Sub(now) > role.MaxNBFDelay {
+			return logical.ErrorResponse("'%s' cannot be later than %s from now", keyNBF, role.MaxNBFDelay), logical.ErrInvalidRequest
+		}
+		validFrom = nbf
+		claims["nbf"] = jwt.NumericDate(nbf.Unix())
+	} else if role.setNBF(config) {
+		claims["nbf"] = jwt.NumericDate(now.Add(-role.NBFBackdate).Unix())
+	}
 
-	if config.SetIAT {
-		claims["iat"] = jwt.NumericDate(now.Unix())
+	// Tokens cannot expire later than the max lease ttl, including any delay before they become valid
+	validTTL := durationMin(ttl, b.System().MaxLeaseTTL()-validFrom.Sub(now))
+	if validTTL <= 0 {
+		return logical.ErrorResponse("'%s' leaves no time before the max lease ttl", keyNBF), logical.ErrInvalidRequest
 	}
 
-	if config.SetNBF {
-		claims["nbf"] = jwt.NumericDate(now.Unix())
+	expiry := validFrom.Add(validTTL)
+	claims["exp"] = jwt.NumericDate(expiry.Unix())
+
+	if role.setIAT(config) {
+		claims["iat"] = jwt.NumericDate(now.Add(-role.IATBackdate).Unix())
 	}
 
-	if config.SetJTI {
-		jti, err := b.idGen.id()
+	if role.setJTI(config) {
+		jti, err := b.idGen.id(role.jtiFormat(config))
 		if err != nil {
 			return logical.ErrorResponse("could not generate 'jti' claim: %v", err), err
 		}
-		claims["jti"] = jti
+		claims["jti"] = role.JtiPrefix + jti
 	}
 
 	if rawSub, ok := claims["sub"]; ok {
@@ -144,7 +228,10 @@ func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, d *fr
 			}
 		case []interface{}:
 			if config.MaxAudiences > -1 && len(aud) > config.MaxAudiences {
-				return logical.ErrorResponse("too many audience claims: %d", len(aud)), logical.ErrInvalidRequest
+				return logical.ErrorResponse("too many audience claims: %d (config restriction)", len(aud)), logical.ErrInvalidRequest
+			}
+			if role.MaxAudiences != nil && *role.MaxAudiences > -1 && len(aud) > *role.MaxAudiences {
+				return logical.ErrorResponse("too many audience claims: %d (role restriction)", len(aud)), logical.ErrInvalidRequest
 			}
 			for _, rawAudEntry := range aud {
 				audEntry, ok := rawAudEntry.(string)
@@ -163,16 +250,41 @@ func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, d *fr
 		}
 	}
 
-	policy, err := b.getPolicy(ctx, req.Storage, config, req.MountPoint)
+	if role.ClaimsSchema != "" {
+		schema, err := parseJSONSchema(role.ClaimsSchema)
+		if err != nil {
+			return nil, fmt.Errorf("invalid claims schema for role %s: %w", roleName, err)
+		}
+
+		normalizedClaims, err := normalizeClaimValue(claims)
+		if err != nil {
+			return logical.ErrorResponse("claims are not valid JSON: %v", err), logical.ErrInvalidRequest
+		}
+
+		if violations := schema.validate(normalizedClaims); len(violations) > 0 {
+			resp := logical.ErrorResponse("claims do not satisfy the role's schema: %s", strings.Join(violations, "; "))
+			// Nested under 'data', which keeps the response an error response
+			resp.Data["data"] = map[string]interface{}{keyViolations: violations}
+			return resp, logical.ErrInvalidRequest
+		}
+	}
+
+	key, err := b.getSigningKey(ctx, req.Storage, config, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return logical.ErrorResponse("unknown key %s", keyName), logical.ErrInvalidRequest
+	}
+
+	policy, err := b.getPolicy(ctx, req.Storage, keyName, key, req.MountPoint)
 	if err != nil {
 		return logical.ErrorResponse("error getting key: %v", err), err
 	}
 
-	signer := &PolicySigner{
-		BackendId:          b.id,
-		SignatureAlgorithm: config.SignatureAlgorithm,
-		Policy:             policy,
-		SignerOptions:      (&jose.SignerOptions{}).WithType("JWT"),
+	signer, err := b.policySigner(ctx, req.Storage, key, policy, req.MountPoint)
+	if err != nil {
+		return nil, err
 	}
 
 	for headerName := range role.Headers {
@@ -185,13 +297,31 @@ func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, d *fr
 		return logical.ErrorResponse("error serializing jwt: %v", err), err
 	}
 
-	resp := b.Secret(jwtSecretsTokenType).Response(
-		map[string]interface{}{
-			"token": token,
-		},
-		map[string]interface{}{},
-	)
-	resp.Secret.TTL = config.TokenTTL
+	respData := map[string]interface{}{
+		keyToken:     token,
+		keyExpiresAt: expiry.UTC().Format(time.RFC3339),
+	}
+
+	var resp *logical.Response
+	if role.issueLease(config) {
+		internalData := map[string]interface{}{
+			internalDataExpiresAt: respData[keyExpiresAt],
+		}
+		if jti, ok := claims["jti"]; ok {
+			internalData[internalDataJTI] = jti
+		}
+
+		resp = b.Secret(jwtSecretsTokenType).Response(respData, internalData)
+		resp.Secret.TTL = expiry.Sub(now)
+	} else {
+		resp = &logical.Response{Data: respData}
+	}
+
+	if requestedTTL > ttl {
+		resp.AddWarning(fmt.Sprintf("requested ttl of %s exceeds the maximum, the token is valid for %s", requestedTTL, validTTL))
+	} else if validTTL < ttl {
+		resp.AddWarning(fmt.Sprintf("'%s' is too far in the future to expire within the max lease ttl, the token is valid for %s", keyNBF, validTTL))
+	}
 
 	return resp, nil
 }
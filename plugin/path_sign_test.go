@@ -18,10 +18,16 @@ package jwtsecrets
 
 import (
 	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"github.com/go-test/deep"
 	"github.com/hashicorp/vault/sdk/logical"
 	"gopkg.in/square/go-jose.v2/jwt"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 )
@@ -134,10 +140,10 @@ func TestSign(t *testing.T) {
 	decoded.NotBefore = nil
 
 	expectedClaims := jwt.Claims{
-		Subject:   "Kif Kroker",
-		Audience:  []string{"Zapp Brannigan"},
-		ID:        "1",
-		Issuer:    role + ".example.com",
+		Subject:  "Kif Kroker",
+		Audience: []string{"Zapp Brannigan"},
+		ID:       "1",
+		Issuer:   role + ".example.com",
 	}
 
 	if diff := deep.Equal(expectedClaims, decoded); diff != nil {
@@ -297,3 +303,811 @@ func TestRejectOverwriteRoleOtherClaim(t *testing.T) {
 		t.Fatalf("expected to get an error from sign. got:%v\n", resp)
 	}
 }
+
+func TestSignEdDSA(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keySignatureAlgorithm: "EdDSA"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	claims := map[string]interface{}{
+		"sub": "Kif Kroker",
+	}
+
+	var decoded jwt.Claims
+	if err := getSignedToken(b, storage, role, claims, map[string]interface{}{}, &decoded, nil); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("Kif Kroker", decoded.Subject); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSignPSS(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keySignatureAlgorithm: "PS256"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{"sub": "Kif Kroker"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	jwks, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	matchingKeys := jwks.Key(parsed.Headers[0].KeyID)
+	if len(matchingKeys) != 1 {
+		t.Fatalf("no unique key for kid %s", parsed.Headers[0].KeyID)
+	}
+
+	publicKey, ok := matchingKeys[0].Key.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("expected RSA key, got %T", matchingKeys[0].Key)
+	}
+
+	parts := strings.Split(token, ".")
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	// RFC 7518 requires the salt length to equal the hash length
+	err = rsa.VerifyPSS(publicKey, crypto.SHA256, hashed[:], signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Errorf("signature verification failed: %v", err)
+	}
+}
+
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return parsed.Headers[0].KeyID
+}
+
+func TestSignPrepublish(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration:   "4s",
+		keyPrepublishDuration: "2s",
+		keyTokenTTL:           "1s",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	firstKeyID := tokenKeyID(t, token)
+
+	time.Sleep(2500 * time.Millisecond)
+
+	// Next key is generated and published, but not yet used for signing
+	token, err = signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(firstKeyID, tokenKeyID(t, token)); diff != nil {
+		t.Error("token should be signed with the current key", diff)
+	}
+
+	jwkSet, err := FetchJWKS(b, storage)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(len(jwkSet.Keys), 2); diff != nil {
+		t.Error("next key should be published", diff)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+
+	token, err = signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(jwkSet.Keys[1].KeyID, tokenKeyID(t, token)); diff != nil {
+		t.Error("token should be signed with the published next key", diff)
+	}
+}
+
+func TestWritePrepublishExceedsRotation(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyRotationDuration:   "1h",
+		keyPrepublishDuration: "2h",
+	}); err == nil {
+		t.Fatal("prepublish period longer than the rotation period should have been rejected")
+	}
+}
+
+func TestSignClaimConstraints(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyAllowedClaims: []string{"sub", "groups", "tenant", "level"},
+		keyClaimConstraints: map[string]interface{}{
+			"tenant": map[string]interface{}{"type": "string", "pattern": "^[a-z]+$"},
+		},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRoleData(b, storage, role, map[string]interface{}{
+		keyIssuer: role + ".example.com",
+		keyClaimConstraints: map[string]interface{}{
+			"groups": map[string]interface{}{"type": "array", "enum": []interface{}{"admin", "dev"}, "max_items": 2},
+			"level":  map[string]interface{}{"type": "integer", "min": 1, "max": 5},
+		},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var claims map[string]interface{}
+	if err := getSignedToken(b, storage, role, map[string]interface{}{
+		"groups": []interface{}{"admin", "dev"},
+		"tenant": "acme",
+		"level":  3,
+	}, map[string]interface{}{}, &claims, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	violations := []struct {
+		claims map[string]interface{}
+		error  string
+	}{
+		{map[string]interface{}{"tenant": "ACME"}, "claim 'tenant' violates 'pattern' constraint"},
+		{map[string]interface{}{"tenant": 5}, "claim 'tenant' violates 'type' constraint"},
+		{map[string]interface{}{"groups": "admin"}, "claim 'groups' violates 'type' constraint"},
+		{map[string]interface{}{"groups": []interface{}{"root"}}, "claim 'groups' violates 'enum' constraint"},
+		{map[string]interface{}{"groups": []interface{}{"admin", "dev", "admin"}}, "claim 'groups' violates 'max_items' constraint"},
+		{map[string]interface{}{"level": 2.5}, "claim 'level' violates 'type' constraint"},
+		{map[string]interface{}{"level": 0}, "claim 'level' violates 'min' constraint"},
+		{map[string]interface{}{"level": 6}, "claim 'level' violates 'max' constraint"},
+	}
+
+	for _, violation := range violations {
+		_, err := signToken(b, storage, role, violation.claims)
+		if err == nil {
+			t.Errorf("claims %v should have failed", violation.claims)
+			continue
+		}
+		if !strings.Contains(err.Error(), violation.error) {
+			t.Errorf("expected error %q, got %v", violation.error, err)
+		}
+	}
+}
+
+func TestSignClaimsSchema(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyAllowedClaims: []string{"sub", "ctx", "authorization_details"}}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	schema := `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"required": ["sub", "ctx", "exp"],
+		"properties": {
+			"iss": {"const": "tester.example.com"},
+			"ctx": {
+				"type": "object",
+				"required": ["tenant"],
+				"properties": {"tenant": {"type": "string", "pattern": "^[a-z]+$"}},
+				"additionalProperties": false
+			},
+			"authorization_details": {
+				"type": "array",
+				"items": {"type": "object", "required": ["type"], "properties": {"type": {"enum": ["payment", "account"]}}}
+			}
+		}
+	}`
+
+	if err := writeRoleData(b, storage, role, map[string]interface{}{
+		keyIssuer:       role + ".example.com",
+		keyClaimsSchema: schema,
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := signToken(b, storage, role, map[string]interface{}{
+		"sub":                   "Zapp Brannigan",
+		"ctx":                   map[string]interface{}{"tenant": "nimbus"},
+		"authorization_details": []interface{}{map[string]interface{}{"type": "payment"}},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign/" + role,
+		Storage:   *storage,
+		Data: map[string]interface{}{keyClaims: map[string]interface{}{
+			"ctx":                   map[string]interface{}{"tenant": "Nimbus", "admin": true},
+			"authorization_details": []interface{}{map[string]interface{}{"type": "refund"}},
+		}},
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil || resp == nil || !resp.IsError() {
+		t.Fatalf("claims violating the schema should have failed, err:%s resp:%#v", err, resp)
+	}
+
+	if diff := deep.Equal([]string{
+		"/authorization_details/0/type: refund is not one of the allowed values",
+		"/ctx/admin: no value is allowed",
+		"/ctx/tenant: does not match pattern ^[a-z]+$",
+		"/sub: is required",
+	}, resp.Data["data"].(map[string]interface{})[keyViolations]); diff != nil {
+		t.Error("violations", diff)
+	}
+}
+
+func TestSignRoleRestrictions(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyMaxAllowedAudiences: 3,
+		keyAllowedClaims:       []string{"sub", "aud", "groups"},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleData(b, storage, "narrow", map[string]interface{}{
+		keyIssuer:              "narrow.example.com",
+		keyMaxAllowedAudiences: 1,
+		keyAllowedClaims:       []string{"sub", "aud"},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRole(b, storage, "wide", "wide.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRoleData(b, storage, "closed", map[string]interface{}{
+		keyIssuer:        "closed.example.com",
+		keyAllowedClaims: []string{},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	claims := map[string]interface{}{"sub": "Kif Kroker", "aud": []interface{}{"nimbus"}}
+	if _, err := signToken(b, storage, "narrow", claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := signToken(b, storage, "narrow", map[string]interface{}{"groups": "crew"}); err == nil {
+		t.Error("claim not allowed by the role should have failed")
+	}
+	if _, err := signToken(b, storage, "narrow", map[string]interface{}{"aud": []interface{}{"nimbus", "planet-express"}}); err == nil {
+		t.Error("audiences exceeding the role maximum should have failed")
+	}
+
+	// Roles without restrictions inherit those of the config
+	if _, err := signToken(b, storage, "wide", map[string]interface{}{"groups": "crew", "aud": []interface{}{"a", "b", "c"}}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if _, err := signToken(b, storage, "wide", map[string]interface{}{"aud": []interface{}{"a", "b", "c", "d"}}); err == nil {
+		t.Error("audiences exceeding the config maximum should have failed")
+	}
+
+	// Roles with an empty list allow no claims
+	if _, err := signToken(b, storage, "closed", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if _, err := signToken(b, storage, "closed", map[string]interface{}{"sub": "Kif Kroker"}); err == nil {
+		t.Error("claim not allowed by a role allowing no claims should have failed")
+	}
+}
+
+// signOption modifies the sign request made by signTokenData.
+type signOption func(req *logical.Request)
+
+// withEntityID makes the sign request as the entity.
+func withEntityID(entityID string) signOption {
+	return func(req *logical.Request) {
+		req.EntityID = entityID
+	}
+}
+
+// withRemoteAddr makes the sign request from the remote address.
+func withRemoteAddr(remoteAddr string) signOption {
+	return func(req *logical.Request) {
+		req.Connection = &logical.Connection{RemoteAddr: remoteAddr}
+	}
+}
+
+// signTokenData signs a token using the role with the request data, returning the response and the token's claims.
+func signTokenData(b *backend, storage *logical.Storage, role string, data map[string]interface{}, options ...signOption) (*logical.Response, map[string]interface{}, error) {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "sign/" + role,
+		Storage:    *storage,
+		Data:       data,
+		MountPoint: "test",
+	}
+	for _, option := range options {
+		option(req)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	token, err := jwt.ParseSigned(resp.Data["token"].(string))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, nil, err
+	}
+
+	return resp, claims, nil
+}
+
+func TestSignIdentityTemplates(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	sys := b.System().(*logical.StaticSystemView)
+	sys.EntityVal = &logical.Entity{
+		ID:       "entity-1",
+		Name:     "kif",
+		Metadata: map[string]string{"team": "nimbus"},
+		Aliases: []*logical.Alias{
+			{MountAccessor: "auth_userpass_1", Name: "kkroker"},
+		},
+	}
+	sys.GroupsVal = []*logical.Group{
+		{ID: "group-1", Name: "crew"},
+		{ID: "group-2", Name: "officers"},
+	}
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyAllowedClaims: []string{"sub", "aud", "name", "team", "groups", "ctx"},
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{
+		"sub":    "{{identity.entity.id}}",
+		"name":   "{{ identity.entity.aliases.auth_userpass_1.name }}@{{identity.entity.name}}",
+		"team":   "{{identity.entity.metadata.team}}",
+		"groups": "{{identity.groups.names}}",
+		"ctx":    map[string]interface{}{"meta": "{{identity.entity.metadata}}"},
+	}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	_, claims, err := signTokenData(b, storage, role, map[string]interface{}{}, withEntityID("entity-1"))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expected := map[string]interface{}{
+		"sub":    "entity-1",
+		"name":   "kkroker@kif",
+		"team":   "nimbus",
+		"groups": []interface{}{"crew", "officers"},
+		"ctx":    map[string]interface{}{"meta": map[string]interface{}{"team": "nimbus"}},
+	}
+	for claim, value := range expected {
+		if diff := deep.Equal(value, claims[claim]); diff != nil {
+			t.Errorf("claim %s: %v", claim, diff)
+		}
+	}
+
+	// The subject is defined by the role, it cannot be provided by the caller
+	if _, err := signToken(b, storage, role, map[string]interface{}{"sub": "Zapp Brannigan"}); err == nil {
+		t.Error("overriding templated claim should have failed")
+	}
+
+	// Requests without an entity cannot resolve templates
+	if _, _, err := signTokenData(b, storage, role, map[string]interface{}{}); err == nil {
+		t.Error("signing without an entity should have failed")
+	}
+
+	// Missing values fail rather than producing empty claims
+	sys.EntityVal.Metadata = map[string]string{}
+	if _, _, err := signTokenData(b, storage, role, map[string]interface{}{}, withEntityID("entity-1")); err == nil {
+		t.Error("signing with missing metadata should have failed")
+	}
+}
+
+func TestSignRoleBindings(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	sys := b.System().(*logical.StaticSystemView)
+	sys.EntityVal = &logical.Entity{
+		ID:       "entity-1",
+		Metadata: map[string]string{"team": "nimbus", "env": "prod"},
+		Aliases: []*logical.Alias{
+			{MountAccessor: "auth_approle_1", Name: "deployer"},
+		},
+	}
+	sys.GroupsVal = []*logical.Group{{ID: "group-1", Name: "crew"}}
+
+	role := "tester"
+
+	if err := writeRoleData(b, storage, role, map[string]interface{}{
+		keyIssuer:              role + ".example.com",
+		keyBoundEntityIDs:      "entity-1,entity-2",
+		keyBoundGroupIDs:       []string{"group-1"},
+		keyBoundEntityMetadata: map[string]interface{}{"team": "nimbus"},
+		keyBoundCIDRs:          "10.0.0.0/8",
+		keyBoundMountAccessors: "auth_approle_1",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, _, err := signTokenData(b, storage, role, map[string]interface{}{}, withEntityID("entity-1"), withRemoteAddr("10.1.2.3")); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, _, err := signTokenData(b, storage, role, map[string]interface{}{}, withEntityID("entity-1"), withRemoteAddr("192.168.1.1")); err == nil {
+		t.Error("remote address outside bound cidrs should have failed")
+	}
+	if _, _, err := signTokenData(b, storage, role, map[string]interface{}{}, withRemoteAddr("10.1.2.3")); err == nil {
+		t.Error("request without an entity should have failed")
+	}
+
+	unbound := []func(entity *logical.Entity){
+		func(entity *logical.Entity) { entity.ID = "entity-3" },
+		func(entity *logical.Entity) { entity.Metadata = map[string]string{"team": "planet-express"} },
+		func(entity *logical.Entity) { entity.Aliases = nil },
+	}
+	for i, update := range unbound {
+		sys.EntityVal = &logical.Entity{
+			ID:       "entity-1",
+			Metadata: map[string]string{"team": "nimbus"},
+			Aliases:  []*logical.Alias{{MountAccessor: "auth_approle_1"}},
+		}
+		update(sys.EntityVal)
+		if _, _, err := signTokenData(b, storage, role, map[string]interface{}{}, withEntityID("entity-1"), withRemoteAddr("10.1.2.3")); err == nil {
+			t.Errorf("unbound entity %d should have failed", i)
+		}
+	}
+
+	sys.EntityVal = &logical.Entity{
+		ID:       "entity-1",
+		Metadata: map[string]string{"team": "nimbus"},
+		Aliases:  []*logical.Alias{{MountAccessor: "auth_approle_1"}},
+	}
+	sys.GroupsVal = nil
+	if _, _, err := signTokenData(b, storage, role, map[string]interface{}{}, withEntityID("entity-1"), withRemoteAddr("10.1.2.3")); err == nil {
+		t.Error("entity outside bound groups should have failed")
+	}
+}
+
+func TestSignTTL(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{
+		keyTokenTTL:    "3m",
+		keyMaxTokenTTL: "1h",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleData(b, storage, "short", map[string]interface{}{
+		keyIssuer: "short.example.com",
+		keyTTL:    "30s",
+		keyMaxTTL: "10m",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRole(b, storage, "default", "default.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	tests := []struct {
+		role    string
+		ttl     string
+		want    time.Duration
+		warning bool
+	}{
+		{"short", "", 30 * time.Second, false},
+		{"short", "5m", 5 * time.Minute, false},
+		{"short", "2h", 10 * time.Minute, true},
+		{"default", "", 3 * time.Minute, false},
+		{"default", "30m", 30 * time.Minute, false},
+		{"default", "2h", time.Hour, true},
+	}
+
+	for _, test := range tests {
+		data := map[string]interface{}{}
+		if test.ttl != "" {
+			data[keyTTL] = test.ttl
+		}
+
+		resp, claims, err := signTokenData(b, storage, test.role, data)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		ttl := time.Duration(claims["exp"].(float64)-claims["iat"].(float64)) * time.Second
+		if diff := deep.Equal(test.want, ttl); diff != nil {
+			t.Errorf("role %s, ttl %q: token ttl %v", test.role, test.ttl, diff)
+		}
+		if diff := deep.Equal(test.want, resp.Secret.TTL); diff != nil {
+			t.Errorf("role %s, ttl %q: lease ttl %v", test.role, test.ttl, diff)
+		}
+		if diff := deep.Equal(test.warning, len(resp.Warnings) > 0); diff != nil {
+			t.Errorf("role %s, ttl %q: warning %v", test.role, test.ttl, diff)
+		}
+	}
+}
+
+func TestSignRoleGeneratedClaims(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeRoleData(b, storage, "overrides", map[string]interface{}{
+		keyIssuer:      "overrides.example.com",
+		keySetNBF:      false,
+		keySetJTI:      false,
+		keyIATBackdate: "30s",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	now := time.Now().Unix()
+
+	_, claims, err := signTokenData(b, storage, "overrides", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if _, ok := claims["nbf"]; ok {
+		t.Error("'nbf' claim should not be set")
+	}
+	if _, ok := claims["jti"]; ok {
+		t.Error("'jti' claim should not be set")
+	}
+	if iat := int64(claims["iat"].(float64)); iat < now-31 || iat > now-29 {
+		t.Errorf("'iat' claim %d should be backdated 30s from %d", iat, now)
+	}
+
+	if err := writeRoleData(b, storage, "skewed", map[string]interface{}{
+		keyIssuer:      "skewed.example.com",
+		keyNBFBackdate: "10s",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	_, claims, err = signTokenData(b, storage, "skewed", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(claims["iat"].(float64)-10, claims["nbf"]); diff != nil {
+		t.Error("'nbf' claim should be backdated 10s", diff)
+	}
+	if _, ok := claims["jti"]; !ok {
+		t.Error("'jti' claim should be set by the config")
+	}
+}
+
+func TestSignNotBefore(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyMaxTokenTTL: "10m"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleData(b, storage, "scheduled", map[string]interface{}{
+		keyIssuer:      "scheduled.example.com",
+		keyTTL:         "5m",
+		keyMaxNBFDelay: "1h",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRole(b, storage, "immediate", "immediate.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	nbf := time.Now().Add(30 * time.Minute).Unix()
+
+	_, claims, err := signTokenData(b, storage, "scheduled", map[string]interface{}{keyNBF: nbf})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if diff := deep.Equal(float64(nbf), claims["nbf"]); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(float64(nbf+300), claims["exp"]); diff != nil {
+		t.Error("token should be valid for its ttl from 'nbf'", diff)
+	}
+
+	if _, _, err := signTokenData(b, storage, "scheduled", map[string]interface{}{keyNBF: time.Now().Add(2 * time.Hour).Unix()}); err == nil {
+		t.Error("'nbf' beyond the role's max delay should have failed")
+	}
+	if _, _, err := signTokenData(b, storage, "scheduled", map[string]interface{}{keyNBF: time.Now().Add(-time.Minute).Unix()}); err == nil {
+		t.Error("'nbf' in the past should have failed")
+	}
+	if _, _, err := signTokenData(b, storage, "immediate", map[string]interface{}{keyNBF: nbf}); err == nil {
+		t.Error("'nbf' for a role without a max delay should have failed")
+	}
+}
+
+func TestSignNotBeforeMaxLeaseTTL(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	b.System().(*logical.StaticSystemView).MaxLeaseTTLVal = time.Hour
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyMaxTokenTTL: "1h"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRoleData(b, storage, "delayed", map[string]interface{}{
+		keyIssuer:      "delayed.example.com",
+		keyMaxNBFDelay: "1h",
+	}); err == nil {
+		t.Error("max nbf delay reaching the max lease ttl should have failed")
+	}
+
+	if err := writeRoleData(b, storage, "scheduled", map[string]interface{}{
+		keyIssuer:      "scheduled.example.com",
+		keyTTL:         "30m",
+		keyMaxNBFDelay: "45m",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// The token's ttl is reduced so it expires, and its lease ends, within the max lease ttl
+	signedAt := time.Now()
+	nbf := signedAt.Add(40 * time.Minute).Unix()
+
+	resp, claims, err := signTokenData(b, storage, "scheduled", map[string]interface{}{keyNBF: nbf})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expiry := time.Unix(int64(claims["exp"].(float64)), 0)
+	if expiry.After(signedAt.Add(time.Hour)) || expiry.Before(signedAt.Add(time.Hour-time.Minute)) {
+		t.Errorf("token expiry %s should be the max lease ttl from signing", expiry)
+	}
+	if resp.Secret.TTL > time.Hour || resp.Secret.TTL < time.Unix(nbf, 0).Sub(signedAt) {
+		t.Errorf("lease ttl %s should cover the token's lifetime within the max lease ttl", resp.Secret.TTL)
+	}
+	if len(resp.Warnings) == 0 {
+		t.Error("reduced ttl should have been warned")
+	}
+}
+
+func TestSignJtiFormat(t *testing.T) {
+	b, storage := getTestBackend(t)
+	b.idGen = jtiGenerator{}
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyJtiFormat: "uuidv1"}); err == nil {
+		t.Error("unknown jti format should have failed")
+	}
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyJtiFormat: JtiFormatULID}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := writeRole(b, storage, "default", "default.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRoleData(b, storage, "prefixed", map[string]interface{}{
+		keyIssuer:    "prefixed.example.com",
+		keyJtiFormat: JtiFormatUUIDv7,
+		keyJtiPrefix: "payments-",
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	_, claims, err := signTokenData(b, storage, "default", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if matched, _ := regexp.MatchString(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`, claims["jti"].(string)); !matched {
+		t.Errorf("'jti' claim %s should be a ulid", claims["jti"])
+	}
+
+	_, claims, err = signTokenData(b, storage, "prefixed", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if matched, _ := regexp.MatchString(`^payments-[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-`, claims["jti"].(string)); !matched {
+		t.Errorf("'jti' claim %s should be a prefixed uuidv7", claims["jti"])
+	}
+}
+
+func TestSignIssueLease(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeRole(b, storage, "leased", "leased.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRoleData(b, storage, "unleased", map[string]interface{}{
+		keyIssuer:     "unleased.example.com",
+		keyIssueLease: false,
+	}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, _, err := signTokenData(b, storage, "leased", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if resp.Secret == nil {
+		t.Error("token should have a lease")
+	}
+
+	resp, _, err = signTokenData(b, storage, "unleased", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if resp.Secret != nil {
+		t.Error("token should not have a lease")
+	}
+	if _, ok := resp.Data[keyToken].(string); !ok {
+		t.Error("response should contain the token")
+	}
+	expiresAt, err := time.Parse(time.RFC3339, resp.Data[keyExpiresAt].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if expiresAt.Before(time.Now().Add(2*time.Minute)) || expiresAt.After(time.Now().Add(3*time.Minute)) {
+		t.Errorf("unexpected expiry %s", expiresAt)
+	}
+
+	// Disabling leases for the mount applies to roles that don't override it
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyIssueLease: false}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, _, err = signTokenData(b, storage, "leased", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if resp.Secret != nil {
+		t.Error("token should not have a lease")
+	}
+}
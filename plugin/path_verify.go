@@ -0,0 +1,231 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"regexp"
+	"time"
+)
+
+const (
+	keyToken  = "token"
+	keyValid  = "valid"
+	keyReason = "reason"
+	keyError  = "error"
+)
+
+// Reasons reported when a token fails verification.
+const (
+	VerifyReasonMalformed        = "malformed"
+	VerifyReasonUnknownKey       = "unknown_key"
+	VerifyReasonRevokedKey       = "revoked_key"
+	VerifyReasonRevokedToken     = "revoked_token"
+	VerifyReasonInvalidSignature = "invalid_signature"
+	VerifyReasonExpired          = "expired"
+	VerifyReasonNotYetValid      = "not_yet_valid"
+	VerifyReasonIssuedInFuture   = "issued_in_future"
+	VerifyReasonInvalidIssuer    = "invalid_issuer"
+	VerifyReasonInvalidAudience  = "invalid_audience"
+)
+
+func pathVerify(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "verify/" + framework.GenericNameRegex(keyRoleName),
+		Fields: map[string]*framework.FieldSchema{
+			keyRoleName: {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+			keyToken: {
+				Type:        framework.TypeString,
+				Description: `Compact serialized JWT to verify.`,
+				Required:    true,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathVerifyWrite,
+			},
+		},
+		HelpSynopsis:    pathVerifyHelpSyn,
+		HelpDescription: pathVerifyHelpDesc,
+	}
+}
+
+func (b *backend) pathVerifyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get(keyRoleName).(string)
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("unknown role"), logical.ErrInvalidRequest
+	}
+
+	rawToken, ok := d.GetOk(keyToken)
+	if !ok {
+		return logical.ErrorResponse("missing token"), logical.ErrInvalidRequest
+	}
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseSigned(rawToken.(string))
+	if err != nil {
+		return verifyFailure(VerifyReasonMalformed, "error parsing jwt: %v", err), nil
+	}
+	if len(token.Headers) != 1 {
+		return verifyFailure(VerifyReasonMalformed, "expected exactly one signature, found %d", len(token.Headers)), nil
+	}
+
+	header := token.Headers[0]
+	if header.KeyID == "" {
+		return verifyFailure(VerifyReasonMalformed, "no 'kid' header set"), nil
+	}
+
+	jwkSet, err := b.getPublicKeys(ctx, req.Storage, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	matchingKeys := jwkSet.Key(header.KeyID)
+	if len(matchingKeys) != 1 {
+		keyIds, err := b.keyIdFormatter(ctx, req.Storage, config, req.MountPoint)
+		if err != nil {
+			return nil, err
+		}
+
+		revoked, err := b.isRevokedKeyId(ctx, req.Storage, keyIds, header.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return verifyFailure(VerifyReasonRevokedKey, "verification key for kid %s has been revoked", header.KeyID), nil
+		}
+		return verifyFailure(VerifyReasonUnknownKey, "no verification key for kid %s", header.KeyID), nil
+	}
+
+	key := matchingKeys[0]
+	if header.Algorithm != key.Algorithm {
+		return verifyFailure(VerifyReasonInvalidSignature, "'alg' header %s does not match key algorithm %s", header.Algorithm, key.Algorithm), nil
+	}
+
+	var standardClaims jwt.Claims
+	claims := map[string]interface{}{}
+	if err := token.Claims(key.Key, &standardClaims, &claims); err != nil {
+		return verifyFailure(VerifyReasonInvalidSignature, "error verifying signature: %v", err), nil
+	}
+
+	if jti, ok := claims["jti"].(string); ok {
+		revocation, err := b.getTokenRevocation(ctx, req.Storage, jti)
+		if err != nil {
+			return nil, err
+		}
+		if revocation != nil {
+			return verifyFailure(VerifyReasonRevokedToken, "token with jti %s has been revoked", jti), nil
+		}
+	}
+
+	issuer, _, err := b.roleIssuer(ctx, req.Storage, config, role)
+	if err != nil {
+		if userErr, ok := err.(errutil.UserError); ok {
+			return logical.ErrorResponse(userErr.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	expected := jwt.Expected{
+		Issuer: issuer,
+		Time:   time.Now(),
+	}
+
+	switch err := standardClaims.ValidateWithLeeway(expected, config.VerifyLeeway); err {
+	case nil:
+	case jwt.ErrExpired:
+		return verifyFailure(VerifyReasonExpired, "token is expired"), nil
+	case jwt.ErrNotValidYet:
+		return verifyFailure(VerifyReasonNotYetValid, "token is not valid yet"), nil
+	case jwt.ErrIssuedInTheFuture:
+		return verifyFailure(VerifyReasonIssuedInFuture, "token was issued in the future"), nil
+	case jwt.ErrInvalidIssuer:
+		return verifyFailure(VerifyReasonInvalidIssuer, "'iss' claim does not match role issuer"), nil
+	default:
+		return verifyFailure(VerifyReasonMalformed, "error validating claims: %v", err), nil
+	}
+
+	for _, aud := range standardClaims.Audience {
+		if matched, _ := regexp.MatchString(role.AudiencePattern, aud); !matched {
+			return verifyFailure(VerifyReasonInvalidAudience, "validation of 'aud' claim failed (doesn't match role restriction)"), nil
+		}
+		if matched, _ := regexp.MatchString(config.AudiencePattern, aud); !matched {
+			return verifyFailure(VerifyReasonInvalidAudience, "validation of 'aud' claim failed (doesn't match config restriction)"), nil
+		}
+	}
+
+	headers := map[string]interface{}{
+		"kid": header.KeyID,
+		"alg": header.Algorithm,
+	}
+	for headerName, headerValue := range header.ExtraHeaders {
+		headers[string(headerName)] = headerValue
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyValid:   true,
+			keyClaims:  claims,
+			keyHeaders: headers,
+		},
+	}, nil
+}
+
+// verifyFailure builds the response returned for a token that failed verification.
+func verifyFailure(reason string, format string, args ...interface{}) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyValid:  false,
+			keyReason: reason,
+			keyError:  fmt.Sprintf(format, args...),
+		},
+	}
+}
+
+const pathVerifyHelpSyn = `
+Verify a signed JWT.
+`
+
+const pathVerifyHelpDesc = `
+Verify a JWT signed by this backend.
+
+The token's 'kid' must reference a key version currently published in the JWKS; tokens signed with
+revoked key versions, and revoked tokens, are rejected. The signature,
+'exp', 'nbf' and 'iat' claims (allowing for the configured verify_leeway), the 'iss' claim against
+the role's issuer, and any 'aud' claims against the role and config audience patterns are checked.
+
+On success 'valid' is true and the decoded 'claims' and 'headers' are returned; otherwise 'valid'
+is false and 'reason' and 'error' describe the failure.
+`
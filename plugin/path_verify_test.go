@@ -0,0 +1,282 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signToken(b *backend, storage *logical.Storage, role string, claims map[string]interface{}) (string, error) {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "sign/" + role,
+		Storage:    *storage,
+		Data:       map[string]interface{}{"claims": claims},
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return "", fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return resp.Data["token"].(string), nil
+}
+
+func verifyToken(b *backend, storage *logical.Storage, role string, token string) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "verify/" + role,
+		Storage:    *storage,
+		Data:       map[string]interface{}{"token": token},
+		MountPoint: "test",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return resp, nil
+}
+
+func expectVerifyFailure(t *testing.T, resp *logical.Response, reason string) {
+	t.Helper()
+
+	if diff := deep.Equal(false, resp.Data[keyValid]); diff != nil {
+		t.Fatal("token should not be valid", diff)
+	}
+	if diff := deep.Equal(reason, resp.Data[keyReason]); diff != nil {
+		t.Error("unexpected failure reason", diff)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{"allowed_headers": []string{"tid"}}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{"tid": "12345"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{"sub": "Kif Kroker", "aud": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Fatal("token should be valid", diff, resp.Data)
+	}
+
+	claims := resp.Data[keyClaims].(map[string]interface{})
+	if diff := deep.Equal("Kif Kroker", claims["sub"]); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(role+".example.com", claims["iss"]); diff != nil {
+		t.Error(diff)
+	}
+
+	headers := resp.Data[keyHeaders].(map[string]interface{})
+	if diff := deep.Equal("12345", headers["tid"]); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal("ES256", headers["alg"]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, role, "not.a.jwt")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonMalformed)
+}
+
+func TestVerifyInvalidSignature(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{"sub": "Kif Kroker"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	other, err := signToken(b, storage, role, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Graft the signature of one token onto the payload of another
+	parts := strings.Split(token, ".")
+	otherParts := strings.Split(other, ".")
+	tampered := strings.Join([]string{parts[0], parts[1], otherParts[2]}, ".")
+
+	resp, err := verifyToken(b, storage, role, tampered)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonInvalidSignature)
+}
+
+func TestVerifyUnknownKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+	other, otherStorage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRole(other, otherStorage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(other, otherStorage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonUnknownKey)
+}
+
+func TestVerifyIssuer(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if err := writeRole(b, storage, "tester1", "tester1.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := writeRole(b, storage, "tester2", "tester2.example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, "tester1", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, "tester2", token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonInvalidIssuer)
+}
+
+func TestVerifyAudience(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{"aud": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyAudiencePattern: "^[a-z]+$"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonInvalidAudience)
+}
+
+func TestVerifyExpired(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyTokenTTL: "1s"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	role := "tester"
+
+	if err := writeRole(b, storage, role, role+".example.com", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := signToken(b, storage, role, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	resp, err := verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectVerifyFailure(t, resp, VerifyReasonExpired)
+
+	// A generous leeway accepts the expired token
+	if _, err := writeConfig(b, storage, map[string]interface{}{keyVerifyLeeway: "1m"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = verifyToken(b, storage, role, token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(true, resp.Data[keyValid]); diff != nil {
+		t.Error("token should be valid within leeway", diff, resp.Data)
+	}
+}
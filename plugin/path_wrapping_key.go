@@ -0,0 +1,109 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"path"
+	"strconv"
+)
+
+const (
+	wrappingKeyName          = "wrapping-key"
+	wrappingKeyStoragePrefix = "import/"
+)
+
+func pathWrappingKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "wrapping_key",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathWrappingKeyRead,
+			},
+		},
+		HelpSynopsis:    pathWrappingKeyHelpSyn,
+		HelpDescription: pathWrappingKeyHelpDesc,
+	}
+}
+
+func (b *backend) pathWrappingKeyRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	wrappingKey, err := b.getWrappingKey(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappingKeyEntry, ok := wrappingKey.Keys[strconv.Itoa(wrappingKey.LatestVersion)]
+	if !ok {
+		return nil, fmt.Errorf("missing wrapping key version")
+	}
+
+	derKey, err := x509.MarshalPKIXPublicKey(wrappingKeyEntry.RSAKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling wrapping key: %w", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyPublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: derKey})),
+		},
+	}, nil
+}
+
+// getWrappingKey gets the RSA key used to wrap imported keys, generating it on first use.
+func (b *backend) getWrappingKey(ctx context.Context, stg logical.Storage) (*keysutil.Policy, error) {
+	b.wrappingKeyLock.Lock()
+	defer b.wrappingKeyLock.Unlock()
+
+	wrappingKey, err := keysutil.LoadPolicy(ctx, stg, path.Join(wrappingKeyStoragePrefix, "policy", wrappingKeyName))
+	if err != nil {
+		return nil, err
+	}
+
+	if wrappingKey != nil {
+		return wrappingKey, nil
+	}
+
+	wrappingKey = keysutil.NewPolicy(keysutil.PolicyConfig{
+		Name:          wrappingKeyName,
+		Type:          keysutil.KeyType_RSA4096,
+		StoragePrefix: wrappingKeyStoragePrefix,
+	})
+
+	if err := wrappingKey.Rotate(ctx, stg, rand.Reader); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Debug("Wrapping Key Generated")
+
+	return wrappingKey, nil
+}
+
+const pathWrappingKeyHelpSyn = `
+Returns the public key used to wrap imported keys.
+`
+
+const pathWrappingKeyHelpDesc = `
+Returns the PEM encoded 4096-bit RSA public key used to wrap keys for import, so private keys are
+never sent to Vault in plaintext. See 'keys/<name>/import' for the wrapping format.
+`
@@ -18,21 +18,65 @@ package jwtsecrets
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/vault/sdk/helper/errutil"
 	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
 	"gopkg.in/square/go-jose.v2"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type PolicySigner struct {
-	BackendId          string
+	KeyIds             *keyIdFormatter
 	SignatureAlgorithm jose.SignatureAlgorithm
 	Policy             *keysutil.Policy
 	SignerOptions      *jose.SignerOptions
+
+	// MinSigningVersion is the earliest key version allowed to sign; it was generated for SignatureAlgorithm
+	// and follows any revoked version.
+	MinSigningVersion int
+
+	// PrepublishPeriod is how long a key version is published before it is used for signing.
+	PrepublishPeriod time.Duration
+}
+
+// policySigner creates a signer for the key's policy.
+func (b *backend) policySigner(ctx context.Context, stg logical.Storage, key *Key, policy *keysutil.Policy, mount string) (*PolicySigner, error) {
+	config, err := b.getConfig(ctx, stg)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := b.getKeyHistory(ctx, stg, policy.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	revocations, err := b.getKeyRevocations(ctx, stg, policy.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	keyIds, err := b.keyIdFormatter(ctx, stg, config, mount)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PolicySigner{
+		KeyIds:             keyIds,
+		SignatureAlgorithm: key.SignatureAlgorithm,
+		Policy:             policy,
+		SignerOptions:      (&jose.SignerOptions{}).WithType("JWT"),
+		MinSigningVersion:  intMax(history.minCurrentVersion(), revocations.minSigningVersion()),
+		PrepublishPeriod:   key.PrepublishPeriod,
+	}, nil
 }
 
 func (ps *PolicySigner) Sign(payload []byte) (*jose.JSONWebSignature, error) {
@@ -41,7 +85,17 @@ func (ps *PolicySigner) Sign(payload []byte) (*jose.JSONWebSignature, error) {
 	ps.Policy.Lock(false)
 	defer ps.Policy.Unlock()
 
-	kid := createKeyId(ps.BackendId, ps.Policy.Name, ps.Policy.LatestVersion)
+	keyVersion := ps.signingVersion()
+
+	var keyEntry *keysutil.KeyEntry
+	if entry, ok := ps.Policy.Keys[strconv.Itoa(keyVersion)]; ok {
+		keyEntry = &entry
+	}
+
+	kid, err := ps.KeyIds.keyId(ps.Policy.Name, keyVersion, keyEntry)
+	if err != nil {
+		return nil, err
+	}
 
 	protected := map[jose.HeaderKey]string{
 		"kid": kid,
@@ -62,7 +116,7 @@ func (ps *PolicySigner) Sign(payload []byte) (*jose.JSONWebSignature, error) {
 	input.WriteByte('.')
 	input.WriteString(base64.RawURLEncoding.EncodeToString(payload))
 
-	signature, err := ps.sign(input.Bytes())
+	signature, err := ps.sign(input.Bytes(), keyVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -84,11 +138,29 @@ func (ps *PolicySigner) Sign(payload []byte) (*jose.JSONWebSignature, error) {
 	return jose.ParseSigned(bytes.NewBuffer(encodedSignature).String())
 }
 
-func (ps *PolicySigner) sign(input []byte) ([]byte, error) {
+// signingVersion returns the latest key version that has been published for the prepublish
+// period or, when no version has, the earliest version available for signing.
+func (ps *PolicySigner) signingVersion() int {
+
+	minVersion := intMin(intMax(ps.MinSigningVersion, ps.Policy.MinDecryptionVersion), ps.Policy.LatestVersion)
+
+	now := time.Now()
+	for version := ps.Policy.LatestVersion; version > minVersion; version-- {
+		key, ok := ps.Policy.Keys[strconv.Itoa(version)]
+		if ok && !key.CreationTime.Add(ps.PrepublishPeriod).After(now) {
+			return version
+		}
+	}
+
+	return minVersion
+}
+
+func (ps *PolicySigner) sign(input []byte, keyVersion int) ([]byte, error) {
 
 	var hash crypto.Hash
 	var hashType keysutil.HashType
 	var sigAlg string
+	var saltLength = rsa.PSSSaltLengthAuto
 	switch ps.SignatureAlgorithm {
 	case jose.RS256:
 		hashType = keysutil.HashTypeSHA2256
@@ -102,6 +174,21 @@ func (ps *PolicySigner) sign(input []byte) ([]byte, error) {
 		hashType = keysutil.HashTypeSHA2512
 		hash = crypto.SHA512
 		sigAlg = "pkcs1v15"
+	case jose.PS256:
+		hashType = keysutil.HashTypeSHA2256
+		hash = crypto.SHA256
+		sigAlg = "pss"
+		saltLength = rsa.PSSSaltLengthEqualsHash
+	case jose.PS384:
+		hashType = keysutil.HashTypeSHA2384
+		hash = crypto.SHA384
+		sigAlg = "pss"
+		saltLength = rsa.PSSSaltLengthEqualsHash
+	case jose.PS512:
+		hashType = keysutil.HashTypeSHA2512
+		hash = crypto.SHA512
+		sigAlg = "pss"
+		saltLength = rsa.PSSSaltLengthEqualsHash
 	case jose.ES256:
 		hashType = keysutil.HashTypeSHA2256
 		hash = crypto.SHA256
@@ -114,19 +201,31 @@ func (ps *PolicySigner) sign(input []byte) ([]byte, error) {
 		hashType = keysutil.HashTypeSHA2512
 		hash = crypto.SHA512
 		sigAlg = ""
+	case jose.EdDSA:
+		// Ed25519 performs its own hashing of the input
+		hashType = keysutil.HashTypeNone
+		hash = 0
+		sigAlg = ""
 	default:
 		return nil, errutil.InternalError{Err: fmt.Sprintf("unsupported signature algorithm: %s", ps.SignatureAlgorithm)}
 	}
 
-	keyVersion := ps.Policy.LatestVersion
-
-	hasher := hash.New()
+	hashedInput := input
+	if hash != 0 {
+		hasher := hash.New()
 
-	// According to documentation, Write() on hash never fails
-	_, _ = hasher.Write(input)
-	hashedInput := hasher.Sum(nil)
+		// According to documentation, Write() on hash never fails
+		_, _ = hasher.Write(input)
+		hashedInput = hasher.Sum(nil)
+	}
 
-	result, err := ps.Policy.Sign(keyVersion, nil, hashedInput, hashType, sigAlg, keysutil.MarshalingTypeJWS)
+	// RFC 7518 requires the PSS salt length to match the hash length
+	result, err := ps.Policy.SignWithOptions(keyVersion, nil, hashedInput, &keysutil.SigningOptions{
+		HashAlgorithm: hashType,
+		Marshaling:    keysutil.MarshalingTypeJWS,
+		SaltLength:    saltLength,
+		SigAlgorithm:  sigAlg,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,100 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"fmt"
+	"github.com/hashicorp/vault/sdk/helper/cidrutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"net"
+)
+
+const (
+	keyBoundEntityIDs      = "bound_entity_ids"
+	keyBoundGroupIDs       = "bound_group_ids"
+	keyBoundEntityMetadata = "bound_entity_metadata"
+	keyBoundCIDRs          = "bound_cidrs"
+	keyBoundMountAccessors = "bound_mount_accessors"
+)
+
+// requiresIdentity returns whether checking the role's bindings requires the caller's identity.
+func (r *Role) requiresIdentity() bool {
+	return len(r.BoundEntityIDs) > 0 || len(r.BoundGroupIDs) > 0 || len(r.BoundEntityMetadata) > 0 || len(r.BoundMountAccessors) > 0
+}
+
+// checkBindings returns an error if the caller of the request is not bound to the role. The caller's identity is
+// required if the role has identity bindings.
+func (r *Role) checkBindings(req *logical.Request, identity *callerIdentity) error {
+	if len(r.BoundCIDRs) > 0 {
+		if req.Connection == nil || req.Connection.RemoteAddr == "" {
+			return fmt.Errorf("remote address of the request is unknown")
+		}
+
+		remoteAddr := req.Connection.RemoteAddr
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			remoteAddr = host
+		}
+
+		if bound, err := cidrutil.IPBelongsToCIDRBlocksSlice(remoteAddr, r.BoundCIDRs); err != nil || !bound {
+			return fmt.Errorf("remote address %s is not bound to the role", remoteAddr)
+		}
+	}
+
+	if !r.requiresIdentity() {
+		return nil
+	}
+
+	entity := identity.entity
+
+	if len(r.BoundEntityIDs) > 0 && !stringInSlice(entity.ID, r.BoundEntityIDs) {
+		return fmt.Errorf("entity %s is not bound to the role", entity.ID)
+	}
+
+	if len(r.BoundGroupIDs) > 0 {
+		bound := false
+		for _, group := range identity.groups {
+			if stringInSlice(group.ID, r.BoundGroupIDs) {
+				bound = true
+				break
+			}
+		}
+		if !bound {
+			return fmt.Errorf("entity %s is not a member of a group bound to the role", entity.ID)
+		}
+	}
+
+	for key, value := range r.BoundEntityMetadata {
+		if entityValue, ok := entity.Metadata[key]; !ok || entityValue != value {
+			return fmt.Errorf("entity %s metadata '%s' does not match the role", entity.ID, key)
+		}
+	}
+
+	if len(r.BoundMountAccessors) > 0 {
+		bound := false
+		for _, alias := range entity.Aliases {
+			if stringInSlice(alias.MountAccessor, r.BoundMountAccessors) {
+				bound = true
+				break
+			}
+		}
+		if !bound {
+			return fmt.Errorf("entity %s has no alias for an auth mount bound to the role", entity.ID)
+		}
+	}
+
+	return nil
+}
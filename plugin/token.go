@@ -18,12 +18,18 @@ package jwtsecrets
 
 import (
 	"context"
+	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"time"
 )
 
 const (
 	jwtSecretsTokenType = "jwt_token"
+
+	// Internal data of token leases, used to revoke the token when the lease is revoked.
+	internalDataJTI       = "jti"
+	internalDataExpiresAt = "expires_at"
 )
 
 func (b *backend) token() *framework.Secret {
@@ -35,11 +41,40 @@ func (b *backend) token() *framework.Secret {
 				Description: "Signed JWT",
 			},
 		},
-		Revoke: tokenRevoke,
+		Revoke: b.tokenRevoke,
 	}
 }
 
-func tokenRevoke(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	// Nothing to do!
+func (b *backend) tokenRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	jti, _ := req.Secret.InternalData[internalDataJTI].(string)
+	if jti == "" {
+		// Tokens signed without a 'jti' claim, or before revocation was supported, cannot be denied
+		return nil, nil
+	}
+
+	rawExpiresAt, _ := req.Secret.InternalData[internalDataExpiresAt].(string)
+	expiresAt, err := time.Parse(time.RFC3339, rawExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry of token lease: %w", err)
+	}
+
+	// Leases are also revoked when they expire, along with their tokens; expired tokens need no revocation
+	if !expiresAt.After(time.Now()) {
+		return nil, nil
+	}
+
+	revocation := &tokenRevocation{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+		RevokedAt: time.Now(),
+		RevokedBy: req.DisplayName,
+		EntityID:  req.EntityID,
+		Reason:    "lease revoked",
+	}
+
+	if err := b.recordTokenRevocation(ctx, req.Storage, revocation); err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
@@ -0,0 +1,155 @@
+//
+// Copyright 2021 Outfox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"path"
+	"time"
+)
+
+const tokenRevocationsPath = "token-revocations"
+
+// tokenRevocation records the revocation of a signed token, by its 'jti' claim.
+type tokenRevocation struct {
+	// JTI is the 'jti' claim of the revoked token.
+	JTI string
+
+	// ExpiresAt is when the revoked token expires, after which the revocation is pruned.
+	ExpiresAt time.Time
+
+	// RevokedAt is when the token was revoked.
+	RevokedAt time.Time
+
+	// RevokedBy is the display name of the token that revoked the token.
+	RevokedBy string
+
+	// EntityID is the identity entity that revoked the token, if any.
+	EntityID string
+
+	// Reason is the operator supplied reason for the revocation.
+	Reason string
+}
+
+// tokenRevocationPath returns the storage path of the revocation of the jti. The jti is hashed as it may
+// contain characters that are not valid in storage paths.
+func tokenRevocationPath(jti string) string {
+	hash := sha256.Sum256([]byte(jti))
+	return path.Join(tokenRevocationsPath, hex.EncodeToString(hash[:]))
+}
+
+func (b *backend) getTokenRevocation(ctx context.Context, stg logical.Storage, jti string) (*tokenRevocation, error) {
+	entry, err := stg.Get(ctx, tokenRevocationPath(jti))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var revocation tokenRevocation
+	if err := entry.DecodeJSON(&revocation); err != nil {
+		return nil, err
+	}
+
+	return &revocation, nil
+}
+
+// recordTokenRevocation records that a token has been revoked. Tokens that are already revoked retain the
+// latest expiry of their revocations.
+func (b *backend) recordTokenRevocation(ctx context.Context, stg logical.Storage, revocation *tokenRevocation) error {
+	existing, err := b.getTokenRevocation(ctx, stg, revocation.JTI)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ExpiresAt.After(revocation.ExpiresAt) {
+		revocation.ExpiresAt = existing.ExpiresAt
+	}
+
+	entry, err := logical.StorageEntryJSON(tokenRevocationPath(revocation.JTI), revocation)
+	if err != nil {
+		return err
+	}
+
+	return stg.Put(ctx, entry)
+}
+
+// listTokenRevocations returns the recorded revocations of all tokens, including those that have expired but
+// are not yet pruned.
+func (b *backend) listTokenRevocations(ctx context.Context, stg logical.Storage) ([]*tokenRevocation, error) {
+	hashes, err := stg.List(ctx, tokenRevocationsPath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	revocations := make([]*tokenRevocation, 0, len(hashes))
+
+	for _, hash := range hashes {
+		entry, err := stg.Get(ctx, path.Join(tokenRevocationsPath, hash))
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+
+		var revocation tokenRevocation
+		if err := entry.DecodeJSON(&revocation); err != nil {
+			return nil, err
+		}
+
+		revocations = append(revocations, &revocation)
+	}
+
+	return revocations, nil
+}
+
+// isExpired reports whether the revoked token has expired, allowing for the verify leeway.
+func (r *tokenRevocation) isExpired(config *Config, now time.Time) bool {
+	return !r.ExpiresAt.Add(config.VerifyLeeway).After(now)
+}
+
+// pruneTokenRevocations removes the revocations of tokens that have expired, allowing for the verify leeway.
+func (b *backend) pruneTokenRevocations(ctx context.Context, stg logical.Storage, config *Config) error {
+	revocations, err := b.listTokenRevocations(ctx, stg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, revocation := range revocations {
+		if !revocation.isExpired(config, now) {
+			continue
+		}
+
+		if err := stg.Delete(ctx, tokenRevocationPath(revocation.JTI)); err != nil {
+			return err
+		}
+
+		if b.Logger().IsDebug() {
+			b.Logger().Debug(fmt.Sprintf("Pruned Token Revocation: jti=%s, expired=%s", revocation.JTI, revocation.ExpiresAt.Format(time.RFC3339)))
+		}
+	}
+
+	return nil
+}